@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+	"gopkg.in/yaml.v2"
+)
+
+// errConflictingBlockFlags is returned when a block is given both inline and as a file
+var errConflictingBlockFlags = errors.New("only one of these flags can be used at a time [block|block-file]")
+
+// options holds the flag values for one block. Entries of the blocks list in the config file
+// start from the top level values and override them, so the yaml keys match the flag names.
+type options struct {
+	Backup       string        `yaml:"backup"`
+	BackupDir    string        `yaml:"backup-dir"`
+	BackupKeep   int           `yaml:"backup-keep"`
+	Block        string        `yaml:"block"`
+	BlockFile    string        `yaml:"block-file"`
+	Check        bool          `yaml:"check"`
+	Create       string        `yaml:"create"`
+	CreateDirs   bool          `yaml:"create-dirs"`
+	Diff         bool          `yaml:"diff"`
+	DirGroup     string        `yaml:"dir-group"`
+	DirMode      string        `yaml:"dir-mode"`
+	DirOwner     string        `yaml:"dir-owner"`
+	Exclude      string        `yaml:"exclude"`
+	FirstMatch   bool          `yaml:"firstmatch"`
+	Group        string        `yaml:"group"`
+	Include      string        `yaml:"include"`
+	Indent       int           `yaml:"indent"`
+	InsertAfter  string        `yaml:"insertafter"`
+	InsertBefore string        `yaml:"insertbefore"`
+	Literal      bool          `yaml:"literal"`
+	LockTimeout  time.Duration `yaml:"lock-timeout"`
+	Marker       string        `yaml:"marker"`
+	MarkerBegin  string        `yaml:"markerbegin"`
+	MarkerEnd    string        `yaml:"markerend"`
+	Mode         string        `yaml:"mode"`
+	Newline      string        `yaml:"newline"`
+	Owner        string        `yaml:"owner"`
+	Path         string        `yaml:"path"`
+	Recursive    bool          `yaml:"recursive"`
+	State        string        `yaml:"state"`
+	UnsafeWrites bool          `yaml:"unsafe-writes"`
+	Validate     string        `yaml:"validate"`
+}
+
+// config converts the flag values into the configuration of the block engine
+func (o options) config() blockinfile.Config {
+	var backupAsBool, _ = strconv.ParseBool(o.Backup)
+	var stateAsBool, _ = strconv.ParseBool(o.State)
+	var createAsBool, _ = strconv.ParseBool(o.Create)
+	return blockinfile.Config{
+		Backup:       backupAsBool,
+		BackupDir:    o.BackupDir,
+		BackupKeep:   o.BackupKeep,
+		Check:        o.Check,
+		MustExist:    !createAsBool,
+		CreateDirs:   o.CreateDirs,
+		Diff:         o.Diff,
+		State:        stateAsBool,
+		Indent:       o.Indent,
+		Block:        o.Block,
+		InsertBefore: o.InsertBefore,
+		InsertAfter:  o.InsertAfter,
+		FirstMatch:   o.FirstMatch,
+		Literal:      o.Literal,
+		BeginMarker:  strings.Replace(o.Marker, "{mark}", o.MarkerBegin, 1),
+		EndMarker:    strings.Replace(o.Marker, "{mark}", o.MarkerEnd, 1),
+		Mode:         o.Mode,
+		Owner:        o.Owner,
+		Group:        o.Group,
+		DirMode:      o.DirMode,
+		DirOwner:     o.DirOwner,
+		DirGroup:     o.DirGroup,
+		UnsafeWrites: o.UnsafeWrites,
+		Validate:     o.Validate,
+		LockTimeout:  o.LockTimeout,
+		Newline:      o.Newline,
+	}
+}
+
+// loadBlocks returns the entries of the blocks list in configFile, each starting from defaults.
+// It returns nil when the config file has no blocks list.
+func loadBlocks(configFile string, defaults options) ([]options, error) {
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the entries twice: for the keys they set, and for their values. Decoding straight into
+	// options keeps the source text of scalars for string fields, e.g. mode 0644 or block y.
+	var keys struct {
+		Blocks []yaml.MapSlice `yaml:"blocks"`
+	}
+	var values struct {
+		Blocks []options `yaml:"blocks"`
+	}
+	if err := yaml.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configFile, err)
+	}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configFile, err)
+	}
+
+	var entries []options
+	for i, block := range keys.Blocks {
+		// Only the keys the entry sets override the defaults
+		entry, err := mergeOptions(defaults, values.Blocks[i], block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %d of blocks in %s: %w", i+1, configFile, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// mergeOptions returns defaults with the fields of entry whose yaml keys are in keys
+func mergeOptions(defaults, entry options, keys yaml.MapSlice) (options, error) {
+	merged := reflect.ValueOf(&defaults).Elem()
+	fields := merged.Type()
+	for _, item := range keys {
+		key := fmt.Sprint(item.Key)
+		found := false
+		for i := 0; i < fields.NumField(); i++ {
+			if fields.Field(i).Tag.Get("yaml") == key {
+				merged.Field(i).Set(reflect.ValueOf(entry).Field(i))
+				found = true
+			}
+		}
+		if !found {
+			return defaults, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return defaults, nil
+}
+
+// readBlocks reads the block of every entry with a block-file from that file, and of every entry with
+// block "-" from stdin, which is read once and shared by those entries
+func readBlocks(entries []options, stdin io.Reader) error {
+	var stdinBlock *string
+	for i := range entries {
+		entry := &entries[i]
+		switch {
+		case entry.BlockFile != "" && entry.Block != "":
+			return errConflictingBlockFlags
+		case entry.BlockFile != "":
+			content, err := ioutil.ReadFile(entry.BlockFile)
+			if err != nil {
+				return fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, err)
+			}
+			entry.Block = normalizeBlock(string(content))
+		case entry.Block == "-":
+			if stdinBlock == nil {
+				content, err := ioutil.ReadAll(stdin)
+				if err != nil {
+					return fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, err)
+				}
+				block := normalizeBlock(string(content))
+				stdinBlock = &block
+			}
+			entry.Block = *stdinBlock
+		}
+	}
+	return nil
+}
+
+// normalizeBlock turns \r\n line breaks into \n, like the block engine does, and drops the trailing
+// line breaks of the content, since the end marker follows on its own line
+func normalizeBlock(content string) string {
+	return strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(content string) string {
+	f, err := ioutil.TempFile("", "config_test*.yml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		log.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadBlocks(t *testing.T) {
+	configFile := writeConfigFile(`
+marker: "# {mark} SHARED"
+blocks:
+  - path: /tmp/hosts
+    block: 127.0.0.1 example
+  - path: /tmp/sshd_config
+    block: PasswordAuthentication no
+    insertafter: ^Port
+    state: false
+    firstmatch: true
+`)
+	defer os.Remove(configFile)
+
+	defaults := options{Marker: "# {mark} SHARED", MarkerBegin: "BEGIN", MarkerEnd: "END", State: "true"}
+	entries, err := loadBlocks(configFile, defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, []options{
+		{Marker: "# {mark} SHARED", MarkerBegin: "BEGIN", MarkerEnd: "END", State: "true",
+			Path: "/tmp/hosts", Block: "127.0.0.1 example"},
+		{Marker: "# {mark} SHARED", MarkerBegin: "BEGIN", MarkerEnd: "END", State: "false",
+			Path: "/tmp/sshd_config", Block: "PasswordAuthentication no", InsertAfter: "^Port", FirstMatch: true},
+	}, entries)
+}
+
+func TestLoadBlocksWithoutBlocksList(t *testing.T) {
+	configFile := writeConfigFile("path: /tmp/hosts\nblock: 127.0.0.1 example\n")
+	defer os.Remove(configFile)
+
+	entries, err := loadBlocks(configFile, options{})
+	assert.NoError(t, err)
+	assert.Nil(t, entries)
+}
+
+func TestLoadBlocksUnknownKey(t *testing.T) {
+	configFile := writeConfigFile("blocks:\n  - path: /tmp/hosts\n    blok: typo\n")
+	defer os.Remove(configFile)
+
+	_, err := loadBlocks(configFile, options{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "entry 1")
+}
+
+func TestLoadBlocksKeepsScalarText(t *testing.T) {
+	configFile := writeConfigFile("blocks:\n  - path: /tmp/hosts\n    mode: 0644\n    block: y\n  - path: /tmp/version\n    block: 1.10\n")
+	defer os.Remove(configFile)
+
+	entries, err := loadBlocks(configFile, options{Mode: "0600"})
+	assert.NoError(t, err)
+	assert.Equal(t, []options{
+		{Path: "/tmp/hosts", Mode: "0644", Block: "y"},
+		{Path: "/tmp/version", Mode: "0600", Block: "1.10"},
+	}, entries)
+}
+
+func TestOptionsConfig(t *testing.T) {
+	config := options{
+		Backup:      "true",
+		State:       "false",
+		Block:       "new block",
+		Marker:      "# {mark} MANAGED BLOCK",
+		MarkerBegin: "BEGIN",
+		MarkerEnd:   "END",
+	}.config()
+
+	assert.True(t, config.Backup)
+	assert.False(t, config.State)
+	assert.Equal(t, "new block", config.Block)
+	assert.Equal(t, "# BEGIN MANAGED BLOCK", config.BeginMarker)
+	assert.Equal(t, "# END MANAGED BLOCK", config.EndMarker)
+}
+
+func TestReadBlocks(t *testing.T) {
+	blockFile := writeConfigFile("-----BEGIN CERTIFICATE-----\r\nMIIB\r\n-----END CERTIFICATE-----\r\n")
+	defer os.Remove(blockFile)
+
+	entries := []options{
+		{BlockFile: blockFile},
+		{Block: "-"},
+		{Block: "inline"},
+		{Block: "-"},
+	}
+	err := readBlocks(entries, strings.NewReader("10.0.0.1 one\n10.0.0.2 two\n\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----", entries[0].Block)
+	assert.Equal(t, "10.0.0.1 one\n10.0.0.2 two", entries[1].Block)
+	assert.Equal(t, "inline", entries[2].Block)
+	assert.Equal(t, "10.0.0.1 one\n10.0.0.2 two", entries[3].Block)
+}
+
+func TestReadBlocksErrors(t *testing.T) {
+	err := readBlocks([]options{{Block: "inline", BlockFile: "/tmp/block"}}, strings.NewReader(""))
+	assert.ErrorIs(t, err, errConflictingBlockFlags)
+
+	err = readBlocks([]options{{BlockFile: "/nonexistent/block"}}, strings.NewReader(""))
+	assert.ErrorIs(t, err, blockinfile.ErrUnreadableFile)
+}
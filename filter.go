@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+)
+
+// stdioPath is the path that reads the file from stdin and writes the result to stdout
+const stdioPath = "-"
+
+var (
+	// errConflictingStdin is returned when both the file and the block are read from stdin
+	errConflictingStdin = errors.New("path and block cannot both be read from stdin")
+	// errMixedFilterPaths is returned when a blocks list mixes path - with files
+	errMixedFilterPaths = errors.New("path - cannot be combined with other paths")
+)
+
+// isFilter reports whether the entries read the file from stdin and write the result to stdout.
+// Either all entries use path - or none of them, and stdin cannot hold both the file and a block.
+func isFilter(entries []options) (bool, error) {
+	filter := entries[0].Path == stdioPath
+	for _, entry := range entries {
+		if (entry.Path == stdioPath) != filter {
+			return false, errMixedFilterPaths
+		}
+		if filter && entry.Block == "-" {
+			return false, errConflictingStdin
+		}
+	}
+	return filter, nil
+}
+
+// filterEntries applies every entry in order to the content read from stdin and writes the result to
+// stdout. Only the content is transformed: backups, file creation, mode, owner, group and validate do
+// not apply. Entries in check mode leave the content as is. Nothing is written when an entry fails.
+func filterEntries(entries []options, stdin io.Reader, stdout io.Writer) ([]entryResult, error) {
+	results := make([]entryResult, len(entries))
+	input, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, err)
+	}
+
+	content := string(input)
+	for i, entry := range entries {
+		results[i].path = entry.Path
+		results[i].check = entry.Check
+		updatedContent, result, err := blockinfile.Apply(content, entry.config())
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		results[i].result = result
+		if !entry.Check {
+			content = updatedContent
+		}
+	}
+
+	if firstError(results) != nil {
+		return results, nil
+	}
+	if _, err := io.WriteString(stdout, content); err != nil {
+		return results, fmt.Errorf("%w: %w", blockinfile.ErrUnwritableFile, err)
+	}
+	return results, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+)
+
+// applyConcurrently applies every target with up to jobs workers and stores the outcomes in results,
+// by index so they keep the order of the targets. Targets sharing a file, including through a symlink,
+// are applied in order by the same worker, so they never race on that file.
+func applyConcurrently(targets []blockinfile.Target, results []entryResult, jobs int) {
+	var groups [][]int
+	groupOf := make(map[string]int)
+	for i, target := range targets {
+		file := target.Path
+		if resolved, err := filepath.EvalSymlinks(target.Path); err == nil {
+			file = resolved
+		}
+		group, ok := groupOf[file]
+		if !ok {
+			group = len(groups)
+			groupOf[file] = group
+			groups = append(groups, nil)
+		}
+		groups[group] = append(groups[group], i)
+	}
+
+	work := make(chan []int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(jobs, len(groups)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				for _, i := range group {
+					results[i].result, results[i].err = blockinfile.ApplyFile(targets[i].Path, targets[i].Config)
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		work <- group
+	}
+	close(work)
+	wg.Wait()
+}
@@ -3,120 +3,263 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
-	"time"
 
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 )
 
-type Config struct {
-	Backup, State                                                  bool
-	Indent                                                         int
-	Block, InsertBefore, InsertAfter, BeginMarker, EndMarker, Path string
-	Mode, Owner, Group                                             string
-}
+// Exit codes returned by the CLI, so callers can react to failures without parsing stderr.
+const (
+	exitError          = 1
+	exitInvalidFlags   = 2
+	exitUnreadableFile = 3
+	exitUnwritableFile = 4
+	exitBackupFailed   = 5
+	exitFileAttributes = 6
+	exitValidation     = 7
+	exitNoBackup       = 8
+	exitLocked         = 9
+)
+
+// errRolledBack is reported for the entries of a transaction that were undone because another entry failed
+var errRolledBack = errors.New("not applied, the transaction was rolled back")
 
 func main() {
-	var indent int
-	var backup, block, insertBefore, insertAfter, marker, markerBegin, markerEnd, path, state, mode, owner, group string
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newApp builds the blockinfile CLI
+func newApp() *cli.App {
+	var opts options
+	var changedExitCode int
+	var output string
+	var transaction bool
+	var jobs int
 
 	flags := []cli.Flag{
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "backup",
 			Usage:       "create a backup file including the timestamp information so you can get the original file back if you somehow clobbered it incorrectly.",
-			Destination: &backup,
+			Destination: &opts.Backup,
 			DefaultText: "false",
 			Value:       "false",
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "backup-dir",
+			Usage:       "The directory to write backups to instead of next to the file. The absolute path of the file is recreated below it.",
+			Destination: &opts.BackupDir,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "backup-keep",
+			Usage:       "The number of backups of the file to keep, removing older ones. 0 keeps all backups.",
+			Destination: &opts.BackupKeep,
+			DefaultText: "0",
+			Value:       0,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name: "block",
-			Usage: `The text to insert inside the marker lines.
+			Usage: `The text to insert inside the marker lines, or - to read it from stdin.
 					If it is missing or an empty string, the block will be removed as if state were specified to absent.`,
-			Destination: &block,
+			Destination: &opts.Block,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "block-file",
+			Usage:       "The file to read the text to insert inside the marker lines from, instead of block.",
+			Destination: &opts.BlockFile,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "changed-exit-code",
+			Usage:       "The exit code to return when the file was changed, or would be changed in check mode. 0 returns success whether or not the file changed.",
+			Destination: &changedExitCode,
+			DefaultText: "0",
+			Value:       0,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "check",
+			Usage:       "Report whether the file would change without modifying, creating or backing up the file.",
+			Destination: &opts.Check,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "create",
+			Usage:       "Create the file if it does not exist. If false, a missing file is an error. Removing a block never creates the file.",
+			Destination: &opts.Create,
+			DefaultText: "true",
+			Value:       "true",
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "create-dirs",
+			Usage:       "Create the missing parent directories of the file, with dir-mode, dir-owner and dir-group.",
+			Destination: &opts.CreateDirs,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "diff",
+			Usage:       "Print a unified diff of the changes made to the file, including mode, owner and group changes.",
+			Destination: &opts.Diff,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "dir-group",
+			Usage:       "Name of the group that should own the parent directories created by create-dirs.",
+			Destination: &opts.DirGroup,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "dir-mode",
+			Usage:       "The permissions of the parent directories created by create-dirs. For example, '0755' or '0750'.",
+			Destination: &opts.DirMode,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "dir-owner",
+			Usage:       "Name of the user that should own the parent directories created by create-dirs.",
+			Destination: &opts.DirOwner,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "exclude",
+			Usage:       "Comma separated glob patterns of file names to skip when path is a glob or recursive walks a directory, e.g. '*.bak,.git'. Matching directories are not walked.",
+			Destination: &opts.Exclude,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "include",
+			Usage:       "Comma separated glob patterns of file names to update when path is a glob or recursive walks a directory, e.g. '*.conf'. All files by default.",
+			Destination: &opts.Include,
 		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:        "indent",
 			Usage:       "The number of spaces to indent the block. Indent must be >= 0.",
-			Destination: &indent,
+			Destination: &opts.Indent,
 			DefaultText: "0",
 			Value:       0,
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name: "insertafter",
-			Usage: `If specified and no begin/ending marker lines are found, the block will be inserted after the last match of specified regular expression.
-					A special value is available; EOF for inserting the block at the end of the file.
+			Usage: `If specified and no begin/ending marker lines are found, the block will be inserted after the last line matching the specified regular expression.
+					A special value is available; EOF for inserting the block at the end of the file, even when the block exists elsewhere.
 					If specified regular expression has no matches, EOF will be used instead.`,
-			Destination: &insertAfter,
+			Destination: &opts.InsertAfter,
 			Value:       "",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name: "insertbefore",
-			Usage: `If specified and no begin/ending marker lines are found, the block will be inserted before the last match of specified regular expression.
+			Usage: `If specified and no begin/ending marker lines are found, the block will be inserted before the last line matching the specified regular expression.
 					A special value is available; BOF for inserting the block at the beginning of the file.
 				    If specified regular expression has no matches, the block will be inserted at the end of the file.`,
-			Destination: &insertBefore,
+			Destination: &opts.InsertBefore,
 			Value:       "",
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "jobs",
+			Usage:       "The number of files to update concurrently, e.g. for a glob matching many files. Blocks of the same file are always applied in order.",
+			Destination: &jobs,
+			DefaultText: "1",
+			Value:       1,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "literal",
+			Usage:       "Match insertafter and insertbefore as plain text anywhere in the file instead of as regular expressions against each line.",
+			Destination: &opts.Literal,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "lock-timeout",
+			Usage:       "How long to wait for another process to release its lock on the file, e.g. '10s'. 0 waits indefinitely.",
+			Destination: &opts.LockTimeout,
+			DefaultText: "0",
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name: "marker",
 			Usage: `The marker line template.
 				    {mark} will be replaced with the values in marker_begin (default="BEGIN") and marker_end (default="END").
 				    Using a custom marker without the {mark} variable may result in the block being repeatedly inserted on subsequent playbook runs.`,
-			Destination: &marker,
+			Destination: &opts.Marker,
 			Value:       "# {mark} MANAGED BLOCK",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "markerbegin",
 			Usage:       "This will be inserted at {mark} in the opening ansible block marker.",
-			Destination: &markerBegin,
+			Destination: &opts.MarkerBegin,
 			DefaultText: "BEGIN",
 			Value:       "BEGIN",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "markerend",
 			Usage:       "This will be inserted at {mark} in the closing ansible block marker.",
-			Destination: &markerEnd,
+			Destination: &opts.MarkerEnd,
 			DefaultText: "END",
 			Value:       "END",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "path",
-			Usage:       "The file to modify. If the path is relative, the working directory of where blockinfile is running will be pre-fixed to the path.",
-			Destination: &path,
+			Usage:       "The file to modify, a glob such as '/etc/nginx/sites-enabled/*.conf', or - to read it from stdin and write the result to stdout. If the path is relative, the working directory of where blockinfile is running will be pre-fixed to the path.",
+			Destination: &opts.Path,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "recursive",
+			Usage:       "When path is a directory, or a glob matching directories, update every file below it that matches include and not exclude.",
+			Destination: &opts.Recursive,
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "state",
 			Usage:       "Whether the block should be there or not.",
-			Destination: &state,
+			Destination: &opts.State,
 			DefaultText: "true",
 			Value:       "true",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "mode",
-			Usage:       "The permissions the resulting file should have. For example, '0644' or '0755'.",
-			Destination: &mode,
+			Usage:       "The permissions the resulting file should have, either octal such as '0644' or symbolic such as 'u+rwx,g-w,o=r'.",
+			Destination: &opts.Mode,
 			Value:       "",
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "newline",
+			Usage:       "The line break to write the markers and the block with, either 'lf', 'crlf' or 'auto' to use the most common line break of the file.",
+			Destination: &opts.Newline,
+			DefaultText: "auto",
+			Value:       "auto",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "output",
+			Usage:       "The format of the result printed after updating the file, either 'text' or 'json'.",
+			Destination: &output,
+			DefaultText: outputText,
+			Value:       outputText,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "owner",
-			Usage:       "Name of the user that should own the file.",
-			Destination: &owner,
+			Usage:       "Name or numeric ID of the user that should own the file.",
+			Destination: &opts.Owner,
 			Value:       "",
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "firstmatch",
+			Usage:       "Insert the block relative to the first match of insertafter or insertbefore instead of the last.",
+			Destination: &opts.FirstMatch,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "group",
-			Usage:       "Name of the group that should own the file.",
-			Destination: &group,
+			Usage:       "Name or numeric ID of the group that should own the file.",
+			Destination: &opts.Group,
 			Value:       "",
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "transaction",
+			Usage:       "Apply all blocks of the config file or none of them. If writing a file fails, the files already written are restored.",
+			Destination: &transaction,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "unsafe-writes",
+			Usage:       "Write the file in place when it cannot be replaced atomically, e.g. for bind-mounted files. Readers may see a partially written file.",
+			Destination: &opts.UnsafeWrites,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "validate",
+			Usage:       "The validation command to run before replacing the file, e.g. 'visudo -cf %s'. %s is replaced by the path of a temp file with the new content. The file is only replaced when the command exits 0.",
+			Destination: &opts.Validate,
+		}),
 		&cli.StringFlag{
 			Name:  "config",
 			Usage: "YAML configuration file containing parameters for blockinfile",
@@ -129,290 +272,172 @@ func main() {
 		Usage:   "insert/update/remove a block of multi-line text surrounded by customizable marker lines",
 		Version: "v0.1.11",
 		Action: func(c *cli.Context) error {
-			var backupAsBool, _ = strconv.ParseBool(backup)
-			var stateAsBool, _ = strconv.ParseBool(state)
-			config := Config{
-				Backup:       backupAsBool,
-				State:        stateAsBool,
-				Indent:       indent,
-				Block:        block,
-				InsertBefore: insertBefore,
-				InsertAfter:  insertAfter,
-				BeginMarker:  strings.Replace(marker, "{mark}", markerBegin, 1),
-				EndMarker:    strings.Replace(marker, "{mark}", markerEnd, 1),
-				Path:         getFullPath(path),
-				Mode:         mode,
-				Owner:        owner,
-				Group:        group,
+			if output != outputText && output != outputJSON {
+				return cli.Exit(fmt.Sprintf("invalid output %q, must be one of [text|json]", output), exitInvalidFlags)
+			}
+			if jobs < 1 {
+				return cli.Exit(fmt.Sprintf("invalid jobs %d, must be >= 1", jobs), exitInvalidFlags)
+			}
+
+			// A blocks list in the config file replaces the single block given by the flags
+			entries := []options{opts}
+			var blocks []options
+			if configFile := c.String("config"); configFile != "" {
+				var err error
+				if blocks, err = loadBlocks(configFile, opts); err != nil {
+					return cli.Exit(err, exitInvalidFlags)
+				}
+				if blocks != nil {
+					entries = blocks
+				}
 			}
 
-			updateBlockInFile(config)
+			filter, err := isFilter(entries)
+			if err != nil {
+				return cli.Exit(err, exitInvalidFlags)
+			}
+			if err := readBlocks(entries, c.App.Reader); err != nil {
+				if errors.Is(err, errConflictingBlockFlags) {
+					return cli.Exit(err, exitInvalidFlags)
+				}
+				return cli.Exit(err, exitCode(err))
+			}
+
+			entries, expanded, unmatched, err := expandEntries(entries)
+			if err != nil {
+				return cli.Exit(err, exitCode(err))
+			}
+
+			var results []entryResult
+			resultWriter := c.App.Writer
+			if filter {
+				// stdout carries the content, so the results go to stderr
+				resultWriter = c.App.ErrWriter
+				if results, err = filterEntries(entries, c.App.Reader, c.App.Writer); err != nil {
+					return cli.Exit(err, exitCode(err))
+				}
+			} else {
+				results = applyEntries(entries, transaction, jobs)
+			}
+			summary := blocks != nil || expanded
+			if output == outputText {
+				for _, pattern := range unmatched {
+					fmt.Fprintf(resultWriter, "%s: no files matched\n", pattern)
+				}
+			}
+			if err := printResults(resultWriter, output, summary, results); err != nil {
+				return err
+			}
+			if err := firstError(results); err != nil {
+				if output == outputJSON || summary {
+					// The error is already part of the printed results
+					return cli.Exit("", exitCode(err))
+				}
+				return cli.Exit(err, exitCode(err))
+			}
+			if changedExitCode != 0 && anyChanged(results) {
+				return cli.Exit("", changedExitCode)
+			}
 			return nil
 		},
-		Before: altsrc.InitInputSourceWithContext(flags, altsrc.NewYamlSourceFromFlagFunc("config")),
-		Flags:  flags,
+		Before:   altsrc.InitInputSourceWithContext(flags, altsrc.NewYamlSourceFromFlagFunc("config")),
+		Flags:    flags,
+		Commands: []*cli.Command{newRestoreCommand()},
 	}
 
 	sort.Sort(cli.FlagsByName(app.Flags))
 	sort.Sort(cli.CommandsByName(app.Commands))
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
-	}
+	return app
 }
 
-func backupFile(sourceFile string) {
-	input, err := ioutil.ReadFile(sourceFile)
-	if err != nil {
-		log.Fatal(err)
-		return
+// applyEntries applies every entry, even after one fails, so the summary covers all of them.
+// Up to jobs files are updated concurrently, unless transaction is set. With transaction either
+// all entries are applied or none; when one fails the others report errRolledBack.
+func applyEntries(entries []options, transaction bool, jobs int) []entryResult {
+	results := make([]entryResult, len(entries))
+	targets := make([]blockinfile.Target, len(entries))
+	for i, entry := range entries {
+		results[i].path = getFullPath(entry.Path)
+		results[i].check = entry.Check
+		targets[i] = blockinfile.Target{Path: results[i].path, Config: entry.config()}
 	}
 
-	var backupFile = sourceFile + "." + time.Now().Format(time.RFC3339)
-
-	if err := ioutil.WriteFile(backupFile, input, 0644); err != nil {
-		fmt.Println("Error creating", backupFile)
-		log.Fatal(err)
-		return
-	}
-}
-
-func checkFlags(config Config) error {
-	if config.Path == "" {
-		return errors.New("required flag \"path\" not set")
+	if !transaction && jobs > 1 {
+		applyConcurrently(targets, results, jobs)
+		return results
 	}
-	if config.InsertBefore != "" && config.InsertAfter != "" {
-		return errors.New("only one of these flags can be used at a time [markerbegin|markerend]")
-	}
-	return nil
-}
-
-// If path is relative, add working directory as prefix to path; otherwise, return the existing full path
-func getFullPath(path string) string {
-	if strings.HasPrefix(path, string(os.PathSeparator)) {
-		return path
-	}
-	wd, _ := os.Getwd()
-	return wd + string(os.PathSeparator) + path
-}
-
-func replaceTextBetweenMarkersInFile(config Config) {
-	// Read entire file content, giving us little control but
-	// making it very simple. No need to close the file.
-	content, err := ioutil.ReadFile(config.Path)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	updatedContent := replaceTextBetweenMarkers(string(content), config)
-	if string(content) != updatedContent {
-		if config.Backup {
-			backupFile(config.Path)
-		}
-
-		f, err := os.OpenFile(config.Path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
-			log.Fatal(err)
+	if !transaction {
+		for i, target := range targets {
+			results[i].result, results[i].err = blockinfile.ApplyFile(target.Path, target.Config)
 		}
-		_, err = f.WriteString(updatedContent)
-		defer f.Close()
-	}
-}
-
-func removeExistingBlock(sourceText, beginMarker, endMarker string) string {
-	// Add \n because markers could have similar prefix, \n will make sure match to end of line
-	beginIndex := strings.LastIndex(sourceText, beginMarker+"\n")
-	if beginIndex >= 0 {
-		sourceText = removeLeadingSpacesOfBlock(sourceText, beginIndex)
-		// After removing leading spaces, reset beginIndex
-		beginIndex := strings.LastIndex(sourceText, beginMarker+"\n")
-
-		endIndex := strings.LastIndex(sourceText, endMarker) + len(endMarker) + 1
-		return sourceText[:beginIndex] + sourceText[endIndex:]
+		return results
 	}
-	return sourceText
-}
 
-func removeLeadingSpacesOfBlock(sourceText string, beginIndex int) string {
-	// Remove any leading spaces of block
-	beginNonSpaceIndex := beginIndex
-	for nonSpaceIndex := beginIndex - 1; nonSpaceIndex >= 0; nonSpaceIndex-- {
-		if sourceText[nonSpaceIndex] != ' ' {
-			break
+	applied, err := blockinfile.ApplyFiles(targets)
+	for i := range results {
+		if applied != nil {
+			results[i].result = applied[i]
+		} else {
+			results[i].err = errRolledBack
 		}
-		beginNonSpaceIndex = nonSpaceIndex
 	}
-	sourceText = sourceText[:beginNonSpaceIndex] + sourceText[beginIndex:]
-	return sourceText
-}
-
-func replaceTextBetweenMarkers(sourceText string, config Config) string {
-	reAddSpaces := regexp.MustCompile(`\r?\n`)
-	paddedBeginMarker := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent), config.BeginMarker)
-	paddedEndMarker := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent), config.EndMarker)
-	paddedReplaceText := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent),
-		reAddSpaces.ReplaceAllLiteralString(config.Block, "\n"+strings.Repeat(" ", config.Indent)))
-
-	switch {
-	case !config.State:
-		return removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
-	case config.InsertBefore != "":
-		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
-
-		var index = strings.LastIndex(sourceText, config.InsertBefore)
-		// Not found, insert at EOF
-		if index < 0 {
-			return fmt.Sprintf("%s%s\n%s\n%s\n",
-				sourceText,
-				paddedBeginMarker,
-				paddedReplaceText,
-				paddedEndMarker)
-		}
-		// Insert before
-		return fmt.Sprintf("%s%s\n%s\n%s\n%s",
-			sourceText[:index],
-			paddedBeginMarker,
-			paddedReplaceText,
-			paddedEndMarker,
-			sourceText[index:])
-	case config.InsertAfter != "":
-		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
-
-		var index = strings.LastIndex(sourceText, config.InsertAfter)
-		// Not found, insert at EOF
-		if index < 0 {
-			return fmt.Sprintf("%s%s\n%s\n%s\n",
-				sourceText,
-				paddedBeginMarker,
-				paddedReplaceText,
-				paddedEndMarker)
-		}
-		// Insert after
-		index = index + len(config.InsertAfter)
-		return fmt.Sprintf("%s\n%s\n%s\n%s%s",
-			sourceText[:index],
-			paddedBeginMarker,
-			paddedReplaceText,
-			paddedEndMarker,
-			sourceText[index:])
-	case strings.Contains(sourceText, config.BeginMarker+"\n"):
-		// Remove any leading spaces before replacing the block in case indentation changed
-		beginIndex := strings.LastIndex(sourceText, config.BeginMarker+"\n")
-		sourceText = removeLeadingSpacesOfBlock(sourceText, beginIndex)
-
-		// Replace existing block
-		reReplaceMarker := regexp.MustCompile(fmt.Sprintf("(?s)%s(.*?)%s",
-			regexp.QuoteMeta(config.BeginMarker)+"\n", regexp.QuoteMeta(config.EndMarker)))
-		return reReplaceMarker.ReplaceAllLiteralString(sourceText,
-			fmt.Sprintf("%s\n%s\n%s",
-				paddedBeginMarker,
-				paddedReplaceText,
-				paddedEndMarker),
-		)
-	default:
-		// Not found, add to EOF
-		return fmt.Sprintf("%s%s\n%s\n%s\n",
-			sourceText,
-			paddedBeginMarker,
-			paddedReplaceText,
-			paddedEndMarker)
+	var transactionErr *blockinfile.TransactionError
+	if errors.As(err, &transactionErr) {
+		results[transactionErr.Index].err = transactionErr.Err
 	}
+	return results
 }
 
-func updateBlockInFile(config Config) {
-	if err := checkFlags(config); err != nil {
-		log.Fatal(err)
-	}
-
-	// Make sure file exists by touching it
-	if err := touchFile(config.Path); err != nil {
-		log.Fatal(err)
-	}
-
-	replaceTextBetweenMarkersInFile(config)
-
-	// Apply ownership and permissions after file modification
-	if err := applyFileAttributes(config); err != nil {
-		log.Fatal(err)
-	}
-}
-
-// applyFileAttributes applies mode, owner, and group settings to the file
-func applyFileAttributes(config Config) error {
-	// Apply owner and group
-	if config.Owner != "" || config.Group != "" {
-		if err := applyOwnership(config.Path, config.Owner, config.Group); err != nil {
-			return err
-		}
-	}
-
-	// Apply mode (permissions)
-	if config.Mode != "" {
-		if err := applyMode(config.Path, config.Mode); err != nil {
-			return err
+// firstError returns the error that made the run fail, ignoring entries rolled back because of it
+func firstError(results []entryResult) error {
+	for _, r := range results {
+		if r.err != nil && !errors.Is(r.err, errRolledBack) {
+			return r.err
 		}
 	}
-
 	return nil
 }
 
-// applyOwnership changes the owner and/or group of the file
-func applyOwnership(path, owner, group string) error {
-	// Build chown command
-	var chownArg string
-
-	if owner != "" && group != "" {
-		chownArg = owner + ":" + group
-	} else if owner != "" {
-		chownArg = owner
-	} else if group != "" {
-		chownArg = ":" + group
-	} else {
-		return nil // Nothing to do
-	}
-
-	cmd := exec.Command("chown", chownArg, path)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to change ownership: %s, error: %w", string(output), err)
-	}
-
-	return nil
-}
-
-// applyMode changes the file permissions
-func applyMode(path, mode string) error {
-	// Parse mode string to os.FileMode
-	// Handle octal mode (e.g., "0644", "644")
-	modeStr := strings.TrimPrefix(mode, "0")
-	modeInt, err := strconv.ParseUint(modeStr, 8, 32)
-	if err != nil {
-		// If parsing as octal fails, try symbolic mode via chmod command
-		return applyModeViaChmod(path, mode)
-	}
-
-	fileMode := os.FileMode(modeInt)
-	if err := os.Chmod(path, fileMode); err != nil {
-		return fmt.Errorf("failed to change mode: %w", err)
-	}
-
-	return nil
-}
-
-// applyModeViaChmod uses the chmod command for symbolic modes (e.g., "u+rwx")
-func applyModeViaChmod(path, mode string) error {
-	cmd := exec.Command("chmod", mode, path)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to change mode via chmod: %s, error: %w", string(output), err)
+// exitCode maps an error returned by blockinfile to the exit code of the CLI
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, blockinfile.ErrMissingPath),
+		errors.Is(err, blockinfile.ErrConflictingInsertFlags),
+		errors.Is(err, blockinfile.ErrInvalidIndent),
+		errors.Is(err, blockinfile.ErrInvalidBackupKeep),
+		errors.Is(err, blockinfile.ErrInvalidLockTimeout),
+		errors.Is(err, blockinfile.ErrInvalidNewline),
+		errors.Is(err, blockinfile.ErrInvalidPattern),
+		errors.Is(err, blockinfile.ErrInvalidMode),
+		errors.Is(err, blockinfile.ErrInvalidOwner),
+		errors.Is(err, blockinfile.ErrInvalidGroup),
+		errors.Is(err, blockinfile.ErrInvalidValidateCommand),
+		errors.Is(err, errInvalidPathPattern):
+		return exitInvalidFlags
+	case errors.Is(err, blockinfile.ErrMissingFile),
+		errors.Is(err, blockinfile.ErrUnreadableFile):
+		return exitUnreadableFile
+	case errors.Is(err, blockinfile.ErrUnwritableFile):
+		return exitUnwritableFile
+	case errors.Is(err, blockinfile.ErrBackupFailed):
+		return exitBackupFailed
+	case errors.Is(err, blockinfile.ErrFileAttributes):
+		return exitFileAttributes
+	case errors.Is(err, blockinfile.ErrValidationFailed):
+		return exitValidation
+	case errors.Is(err, blockinfile.ErrLocked):
+		return exitLocked
+	default:
+		return exitError
 	}
-	return nil
 }
 
-// Does not update the file’s modification timestamp.
-func touchFile(path string) error {
-	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+// If path is relative, add working directory as prefix to path; otherwise, return the existing full path
+func getFullPath(path string) string {
+	if path == "" || strings.HasPrefix(path, string(os.PathSeparator)) {
+		return path
 	}
-	defer file.Close()
-	return nil
+	wd, _ := os.Getwd()
+	return wd + string(os.PathSeparator) + path
 }
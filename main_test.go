@@ -1,1021 +1,404 @@
 package main
 
 import (
-	"github.com/sergi/go-diff/diffmatchpatch"
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
-	"time"
-)
-
-func compare(t *testing.T, expected string, actual string) {
-	if expected != actual {
-		dmp := diffmatchpatch.New()
-		diffs := dmp.DiffMain(actual, expected, false)
-		t.Errorf("The differences are:%s", dmp.DiffPrettyText(diffs))
-	}
-}
-
-// getModTimeFromFile returns the modification time of an already opened file.
-func getModTimeFromFile(file *os.File) (time.Time, error) {
-	info, err := file.Stat()
-	if err != nil {
-		return time.Time{}, err
-	}
-	return info.ModTime(), nil
-}
-
-func TestEmptyFile(t *testing.T) {
-	var origText = ""
-	var expected = `# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-
-	if expected != replaceTextBetweenMarkers(origText, config) {
-		t.Error("block should have been added to EOF")
-	}
-}
-
-func TestNotFindTextToReplace(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-`
-	var expected = `
-line 1
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-pattern not exist before
-# END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "pattern not exist before",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestFindOneMatchToReplace(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-original block of text
-# END MANAGED BLOCK
-line 4
-line 5
-`
-	var expected = `
-line 1
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-line 4
-line 5
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestDollarSignToReplace(t *testing.T) {
-	var origText = `
-line 1
-$USER
-$20
-line 2
-line 3
-#!/bin/bash
-$USER1 original block of text $VAR1
-# managed file end
-line 4
-line 5
-`
-	var expected = `
-line 1
-$USER
-$20
-line 2
-line 3
-#!/bin/bash
-$1 swapped with $VAR2 lorem ipsum. $$$ lorem ipsum. Echo $USER
-# managed file end
-line 4
-line 5
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "$1 swapped with $VAR2 lorem ipsum. $$$ lorem ipsum. Echo $USER",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "#!/bin/bash",
-		EndMarker:    "# managed file end",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
 
-func TestNoIndentToWithIndent(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK
-original block of text
-# END MANAGED BLOCK
-`
-	var expected = `
-line 1
-line 2
-    # BEGIN MANAGED BLOCK
-    swapped with me
-    # END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestWithIndentToNoIndent(t *testing.T) {
-	var origText = `
-line 1
-line 2
-    # BEGIN MANAGED BLOCK
-    original block of text
-    # END MANAGED BLOCK
-`
-	var expected = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	var actual = replaceTextBetweenMarkers(origText, config)
-	compare(t, expected, actual)
-}
-
-func TestFindMultipleMatchToReplace(t *testing.T) {
-	var origText = `
-line 1
-# BEGIN MANAGED BLOCK
-original block of text 1
-# END MANAGED BLOCK
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-original block of text 2
-# END MANAGED BLOCK
-line 4
-line 5
-`
-	var expected = `
-line 1
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-line 4
-line 5
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestInsertBlockSimilarPrefixMarker(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-`
-	var expected = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-# BEGIN MANAGED BLOCK - Common
-new block
-# END MANAGED BLOCK - Common
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "new block",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
-		EndMarker:    "# END MANAGED BLOCK - Common",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestReplaceBlockSimilarPrefixMarker(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-# BEGIN MANAGED BLOCK - Common
-new block
-# END MANAGED BLOCK - Common
-`
-	var expected = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-# BEGIN MANAGED BLOCK - Common
-replaced similar prefix block
-# END MANAGED BLOCK - Common
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "replaced similar prefix block",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
-		EndMarker:    "# END MANAGED BLOCK - Common",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
 
-func TestRemoveBlockSimilarPrefixMarker(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-# BEGIN MANAGED BLOCK - Common
-new block
-# END MANAGED BLOCK - Common
-`
-	var expected = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        false,
-		Indent:       0,
-		Block:        "new block",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
-		EndMarker:    "# END MANAGED BLOCK - Common",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+// runApp runs the CLI with args and returns what it printed and its exit code
+func runApp(args ...string) (string, int) {
+	var out bytes.Buffer
+	code := runAppWithIO(strings.NewReader(""), &out, &out, args...)
+	return out.String(), code
 }
 
-func TestInsertBeforeExistingBlock(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK
-original block of text
-# END MANAGED BLOCK
-line 3
-`
-	var expected = `
-line 1
-    # BEGIN MANAGED BLOCK
-    swapped with me
-    # END MANAGED BLOCK
-line 2
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "swapped with me",
-		InsertBefore: "line 2",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+// runAppWithIO runs the CLI with args, reading stdin and writing to stdout and stderr, and returns its exit code
+func runAppWithIO(stdin io.Reader, stdout, stderr io.Writer, args ...string) int {
+	code := 0
 
-func TestInsertBeforeNonExistingBlock(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK
-original block of text
-# END MANAGED BLOCK
-line 3
-`
-	var expected = `
-line 1
-line 2
-line 3
-    # BEGIN MANAGED BLOCK
-    swapped with me
-    # END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "swapped with me",
-		InsertBefore: "i do not exist",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
+	app := newApp()
+	app.Reader = stdin
+	app.Writer = stdout
+	app.ErrWriter = stderr
+	app.ExitErrHandler = func(c *cli.Context, err error) {
+		if err == nil {
+			return
+		}
+		if exitErr, ok := err.(cli.ExitCoder); ok {
+			code = exitErr.ExitCode()
+		}
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintln(stderr, msg)
+		}
 	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
-
-func TestInsertBeforeBlockSimilarPrefixMarker(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-`
-	var expected = `
-    # BEGIN MANAGED BLOCK - Common
-    new block
-    # END MANAGED BLOCK - Common
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "new block",
-		InsertBefore: "line 1",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
-		EndMarker:    "# END MANAGED BLOCK - Common",
-		Path:         "",
+	if err := app.Run(append([]string{"blockinfile"}, args...)); err != nil && code == 0 {
+		code = exitError
 	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+	return code
 }
 
-func TestInsertAfterBlockSimilarPrefixMarker(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-`
-	var expected = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK - Common Global
-original block of text
-# END MANAGED BLOCK - Common Global
-line 3
-    # BEGIN MANAGED BLOCK - Common
-    new block
-    # END MANAGED BLOCK - Common
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "new block",
-		InsertBefore: "",
-		InsertAfter:  "line 3",
-		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
-		EndMarker:    "# END MANAGED BLOCK - Common",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+func TestGetFullPath(t *testing.T) {
+	wd, _ := os.Getwd()
 
-func TestInsertAfterExistingBlock(t *testing.T) {
-	var origText = `
-line 1
-line 2
-# BEGIN MANAGED BLOCK
-original block of text
-# END MANAGED BLOCK
-line 3
-`
-	var expected = `
-line 1
-    # BEGIN MANAGED BLOCK
-    swapped with me
-    # END MANAGED BLOCK
-line 2
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "line 1",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+	assert.Equal(t, wd, getFullPath(wd))
+	assert.Equal(t, "", getFullPath(""))
+	assert.Equal(t, wd+"/../foo/bar", getFullPath("../foo/bar"))
+	assert.Equal(t, wd+"/./foo/bar", getFullPath("./foo/bar"))
+	assert.Equal(t, wd+"/foo/bar", getFullPath("foo/bar"))
+	assert.Equal(t, "/fullpath/foo/bar", getFullPath("/fullpath/foo/bar"))
 }
 
-func TestInsertAfterNoExistingBlock(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-`
-	var expected = `
-line 1
-line 2
-line 3
-    # BEGIN MANAGED BLOCK
-    swapped with me
-    # END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       4,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "line 3",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitInvalidFlags, exitCode(blockinfile.ErrMissingPath))
+	assert.Equal(t, exitInvalidFlags, exitCode(blockinfile.ErrConflictingInsertFlags))
+	assert.Equal(t, exitUnreadableFile, exitCode(fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, os.ErrPermission)))
+	assert.Equal(t, exitUnreadableFile, exitCode(fmt.Errorf("%w: /etc/hots", blockinfile.ErrMissingFile)))
+	assert.Equal(t, exitUnwritableFile, exitCode(fmt.Errorf("%w: %w", blockinfile.ErrUnwritableFile, os.ErrPermission)))
+	assert.Equal(t, exitBackupFailed, exitCode(fmt.Errorf("%w: %w", blockinfile.ErrBackupFailed, os.ErrPermission)))
+	assert.Equal(t, exitFileAttributes, exitCode(fmt.Errorf("%w: %w", blockinfile.ErrFileAttributes, os.ErrPermission)))
+	assert.Equal(t, exitValidation, exitCode(fmt.Errorf("%w: visudo -cf %%s", blockinfile.ErrValidationFailed)))
+	assert.Equal(t, exitLocked, exitCode(fmt.Errorf("%w: /etc/hosts", blockinfile.ErrLocked)))
+	assert.Equal(t, exitInvalidFlags, exitCode(fmt.Errorf("%w \"u+q\"", blockinfile.ErrInvalidMode)))
+	assert.Equal(t, exitInvalidFlags, exitCode(fmt.Errorf("%w \"nobody1\"", blockinfile.ErrInvalidOwner)))
+	assert.Equal(t, exitInvalidFlags, exitCode(fmt.Errorf("%w \"nogroup1\"", blockinfile.ErrInvalidGroup)))
+	assert.Equal(t, exitError, exitCode(errors.New("unexpected")))
+}
+
+func TestChangedExitCode(t *testing.T) {
+	f, err := ioutil.TempFile("", "exit_code_test")
+	if err != nil {
+		log.Fatal(err)
 	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+	defer f.Close()
+	defer os.Remove(f.Name())
 
-func TestInsertAfterNoExistingBlockNoMatchInsertAfter(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-`
-	var expected = `
-line 1
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-`
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "XXXX",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+	_, code := runApp("--path", f.Name(), "--block", "test block", "--changed-exit-code", "100", "--check")
+	assert.Equal(t, 100, code)
 
-func TestStateIsFalseNoIndent(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-# BEGIN MANAGED BLOCK
-swapped with me
-# END MANAGED BLOCK
-`
-	var expected = `
-line 1
-line 2
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        false,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "XXXX",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+	_, code = runApp("--path", f.Name(), "--block", "test block", "--changed-exit-code", "100")
+	assert.Equal(t, 100, code)
 
-func TestStateIsFalseWithIndent(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-      # BEGIN MANAGED BLOCK
-      swapped with me
-      # END MANAGED BLOCK
-`
-	var expected = `
-line 1
-line 2
-line 3
-`
-	config := Config{
-		Backup:       false,
-		State:        false,
-		Indent:       0,
-		Block:        "swapped with me",
-		InsertBefore: "XXXX",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
-}
+	_, code = runApp("--path", f.Name(), "--block", "test block", "--changed-exit-code", "100")
+	assert.Equal(t, 0, code)
 
-func TestMultiLineBlock(t *testing.T) {
-	var origText = `
-# BEGIN MANAGED BLOCK
-  <?PHP
-    phpinfo();
-# END MANAGED BLOCK
-`
-	var expected = `
-# BEGIN MANAGED BLOCK
-  <?PHP
-    phpinfo();
-    # test
-# END MANAGED BLOCK
-`
-	config := Config{
-		Backup: false,
-		State:  true,
-		Indent: 0,
-		Block: "  <?PHP\n" +
-			"    phpinfo();\n" +
-			"    # test",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+	_, code = runApp("--path", f.Name(), "--block", "new block")
+	assert.Equal(t, 0, code)
 }
 
-func TestMarkerWithStarAndAsterisk(t *testing.T) {
-	var origText = `
-#!/usr/bin/php -q
-  <?PHP
-    phpinfo(); $1 $USER1
-/* managed file end */ ?>
-`
-	var expected = `
-#!/usr/bin/php -q
-  <?PHP
-    phpinfo(); $1 $USER1
-    # test
-/* managed file end */ ?>
-`
-	config := Config{
-		Backup: false,
-		State:  true,
-		Indent: 0,
-		Block: "  <?PHP\n" +
-			"    phpinfo(); $1 $USER1\n" +
-			"    # test",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "#!/usr/bin/php -q",
-		EndMarker:    "/* managed file end */ ?>",
-		Path:         "",
-	}
-	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+func TestInvalidOutput(t *testing.T) {
+	out, code := runApp("--path", "/tmp/file", "--output", "xml")
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Contains(t, out, "invalid output")
 }
 
-func TestExistingFileAddBlock(t *testing.T) {
-	var origText = `
-line 1
-line 2
-line 3
-`
-	var expected = `
-line 1
-line 2
-line 3
-      # BEGIN MANAGED BLOCK
-      swapped with me
-      # END MANAGED BLOCK
-`
-	f, err := ioutil.TempFile("", "sample")
+func TestBlockFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "block_file_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = f.WriteString(origText)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	defer f.Close()
-
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       6,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         f.Name(),
-	}
-	fBeforeModTime, err := getModTimeFromFile(f)
-	updateBlockInFile(config)
-	fAfterModTime, err := getModTimeFromFile(f)
-	if fBeforeModTime.UnixNano() > fAfterModTime.UnixNano() {
-		log.Fatal("Expected fAfterModTime to be after fBeforeModTime")
-	}
-
-	actual, err := ioutil.ReadFile(config.Path)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	defer os.Remove(f.Name())
+	blockFile := writeConfigFile("10.0.0.1 one\n10.0.0.2 two\n")
+	defer os.Remove(blockFile)
+
+	_, code := runApp("--path", f.Name(), "--block-file", blockFile)
+	assert.Equal(t, 0, code)
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "# BEGIN MANAGED BLOCK\n10.0.0.1 one\n10.0.0.2 two\n# END MANAGED BLOCK\n", string(actual))
 
-	compare(t, expected, string(actual))
+	out, code := runApp("--path", f.Name(), "--block-file", blockFile, "--block", "inline")
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Contains(t, out, errConflictingBlockFlags.Error())
 }
 
-func TestFileNotExistAddBlock(t *testing.T) {
-	var expected = `      # BEGIN MANAGED BLOCK
-      swapped with me
-      # END MANAGED BLOCK
-`
-	f, err := ioutil.TempFile("", "sample")
+func TestCreateFalse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "create_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
+	defer os.RemoveAll(dir)
+	path := dir + "/missing.txt"
 
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       6,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         f.Name(),
-	}
-	fBeforeModTime, err := getModTimeFromFile(f)
-	updateBlockInFile(config)
-	fAfterModTime, err := getModTimeFromFile(f)
-	if fBeforeModTime.UnixNano() > fAfterModTime.UnixNano() {
-		log.Fatal("Expected fAfterModTime to be after fBeforeModTime")
-	}
-	updateBlockInFile(config)
+	out, code := runApp("--path", path, "--block", "test block", "--create", "false")
+	assert.Equal(t, exitUnreadableFile, code)
+	assert.Contains(t, out, blockinfile.ErrMissingFile.Error())
+	assert.NoFileExists(t, path)
 
-	actual, err := ioutil.ReadFile(config.Path)
-	if err != nil {
-		log.Fatal(err)
-	}
+	configFile := writeConfigFile("path: " + path + "\nblock: test block\ncreate: \"false\"\n")
+	defer os.Remove(configFile)
+	_, code = runApp("--config", configFile)
+	assert.Equal(t, exitUnreadableFile, code)
+	assert.NoFileExists(t, path)
 
-	defer os.Remove(f.Name())
+	_, code = runApp("--path", path, "--state", "false")
+	assert.Equal(t, 0, code)
+	assert.NoFileExists(t, path)
 
-	compare(t, expected, string(actual))
+	_, code = runApp("--path", path, "--block", "test block")
+	assert.Equal(t, 0, code)
+	assert.FileExists(t, path)
 }
 
-func TestNoChange(t *testing.T) {
-	var origText = `
-      # BEGIN MANAGED BLOCK
-      swapped with me
-      # END MANAGED BLOCK
-`
-	var expected = `
-      # BEGIN MANAGED BLOCK
-      swapped with me
-      # END MANAGED BLOCK
-`
-	f, err := ioutil.TempFile("", "sample")
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = f.WriteString(origText)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer f.Close()
-
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       6,
-		Block:        "swapped with me",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         f.Name(),
-	}
-	fBeforeModTime, err := getModTimeFromFile(f)
-	updateBlockInFile(config)
-	fAfterModTime, err := getModTimeFromFile(f)
-	if fBeforeModTime.UnixNano() != fAfterModTime.UnixNano() {
-		log.Fatal("File was not updated so expected fAfterModTime to be equal to fBeforeModTime")
-	}
-
-	actual, err := ioutil.ReadFile(config.Path)
+func TestCreateDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "create_dirs_test")
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
+	path := dir + "/conf.d/missing.conf"
 
-	defer os.Remove(f.Name())
-
-	compare(t, expected, string(actual))
-}
+	_, code := runApp("--path", path, "--block", "test block")
+	assert.Equal(t, exitUnwritableFile, code)
 
-func TestGetFullPath(t *testing.T) {
-	wd, _ := os.Getwd()
-
-	assert.Equal(t, wd, getFullPath(wd))
-	assert.Equal(t, wd+"/../foo/bar", getFullPath("../foo/bar"))
-	assert.Equal(t, wd+"/./foo/bar", getFullPath("./foo/bar"))
-	assert.Equal(t, wd+"/foo/bar", getFullPath("foo/bar"))
-	assert.Equal(t, "/fullpath/foo/bar", getFullPath("/fullpath/foo/bar"))
+	_, code = runApp("--path", path, "--block", "test block", "--create-dirs", "--dir-mode", "0700")
+	assert.Equal(t, 0, code)
+	assert.FileExists(t, path)
+	info, err := os.Stat(dir + "/conf.d")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
 }
 
-func TestApplyModeOctal(t *testing.T) {
-	f, err := ioutil.TempFile("", "mode_test")
+func TestMultipleBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
-
-	// Test octal mode with leading 0
-	err = applyMode(f.Name(), "0755")
-	assert.NoError(t, err)
-
-	info, err := os.Stat(f.Name())
+	defer os.RemoveAll(dir)
+
+	configFile := writeConfigFile(`
+indent: 2
+blocks:
+  - path: ` + dir + `/a.txt
+    block: block 1
+  - path: ` + dir + `/a.txt
+    block: block 2
+    markerbegin: BEGIN 2
+    markerend: END 2
+  - path: ` + dir + `/b.txt
+    block: block 3
+    indent: 0
+`)
+	defer os.Remove(configFile)
+
+	out, code := runApp("--config", configFile, "--changed-exit-code", "100")
+	assert.Equal(t, 100, code)
+	assert.Equal(t, dir+"/a.txt: block inserted\n"+dir+"/a.txt: block inserted\n"+dir+"/b.txt: block inserted\n", out)
+
+	actual, err := ioutil.ReadFile(dir + "/a.txt")
 	assert.NoError(t, err)
-	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
-
-	// Test octal mode without leading 0
-	err = applyMode(f.Name(), "644")
+	assert.Equal(t, `  # BEGIN MANAGED BLOCK
+  block 1
+  # END MANAGED BLOCK
+  # BEGIN 2 MANAGED BLOCK
+  block 2
+  # END 2 MANAGED BLOCK
+`, string(actual))
+
+	actual, err = ioutil.ReadFile(dir + "/b.txt")
 	assert.NoError(t, err)
+	assert.Equal(t, "# BEGIN MANAGED BLOCK\nblock 3\n# END MANAGED BLOCK\n", string(actual))
 
-	info, err = os.Stat(f.Name())
-	assert.NoError(t, err)
-	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	out, code = runApp("--config", configFile)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, dir+"/a.txt: unchanged\n"+dir+"/a.txt: unchanged\n"+dir+"/b.txt: unchanged\n", out)
 }
 
-func TestApplyModeSymbolic(t *testing.T) {
-	f, err := ioutil.TempFile("", "mode_test")
+func TestMultipleBlocksContinueAfterFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
+	defer os.RemoveAll(dir)
 
-	// Set initial mode
-	os.Chmod(f.Name(), 0644)
+	configFile := writeConfigFile(`
+blocks:
+  - path: ` + dir + `
+    block: block 1
+  - path: ` + dir + `/b.txt
+    block: block 2
+`)
+	defer os.Remove(configFile)
 
-	// Test symbolic mode
-	err = applyMode(f.Name(), "u+x")
-	assert.NoError(t, err)
-
-	info, err := os.Stat(f.Name())
-	assert.NoError(t, err)
-	// Should now be 0744 (added execute for user)
-	assert.Equal(t, os.FileMode(0744), info.Mode().Perm())
+	out, code := runApp("--config", configFile)
+	assert.Equal(t, exitUnwritableFile, code)
+	assert.Contains(t, out, dir+": failed: ")
+	assert.Contains(t, out, dir+"/b.txt: block inserted\n")
+	assert.FileExists(t, dir+"/b.txt")
 }
 
-func TestApplyOwnership(t *testing.T) {
-	// This test requires running as root, so we skip if not root
-	if os.Geteuid() != 0 {
-		t.Skip("Skipping test that requires root privileges")
+func TestMultipleBlocksTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks_test")
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	f, err := ioutil.TempFile("", "ownership_test")
+	defer os.RemoveAll(dir)
+
+	configFile := writeConfigFile(`
+transaction: true
+blocks:
+  - path: ` + dir + `/a.txt
+    block: block 1
+  - path: ` + dir + `/missing/b.txt
+    block: block 2
+`)
+	defer os.Remove(configFile)
+
+	out, code := runApp("--config", configFile)
+	assert.Equal(t, exitUnwritableFile, code)
+	assert.Contains(t, out, dir+"/a.txt: failed: "+errRolledBack.Error()+"\n")
+	assert.Contains(t, out, dir+"/missing/b.txt: failed: "+blockinfile.ErrUnwritableFile.Error())
+	assert.NoFileExists(t, dir+"/a.txt")
+}
+
+func TestFilter(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := runAppWithIO(strings.NewReader("line 1\n"), &stdout, &stderr,
+		"--path", "-", "--block", "test block", "--backup", "true", "--mode", "0600", "--changed-exit-code", "100")
+	assert.Equal(t, 100, code)
+	assert.Equal(t, "line 1\n# BEGIN MANAGED BLOCK\ntest block\n# END MANAGED BLOCK\n", stdout.String())
+	assert.Empty(t, stderr.String())
+	assert.NoFileExists(t, "-")
+
+	stdout.Reset()
+	code = runAppWithIO(strings.NewReader("line 1\n"), &stdout, &stderr, "--path", "-", "--block", "test block", "--check", "--diff")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "line 1\n", stdout.String())
+	assert.Contains(t, stderr.String(), "+test block\n")
+	assert.Contains(t, stderr.String(), "-: block would be inserted\n")
+
+	stdout.Reset()
+	stderr.Reset()
+	code = runAppWithIO(strings.NewReader("line 1\n"), &stdout, &stderr, "--path", "-", "--block", "test block", "--insertafter", "(")
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), blockinfile.ErrInvalidPattern.Error())
+}
+
+func TestFilterInvalidFlags(t *testing.T) {
+	out, code := runApp("--path", "-", "--block", "-")
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Contains(t, out, errConflictingStdin.Error())
+
+	configFile := writeConfigFile(`
+blocks:
+  - path: "-"
+    block: block 1
+  - path: /tmp/b.txt
+    block: block 2
+`)
+	defer os.Remove(configFile)
+	out, code = runApp("--config", configFile)
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Contains(t, out, errMixedFilterPaths.Error())
+}
+
+func TestFilterMultipleBlocks(t *testing.T) {
+	configFile := writeConfigFile(`
+path: "-"
+blocks:
+  - block: block 1
+  - block: block 2
+    markerbegin: BEGIN 2
+    markerend: END 2
+`)
+	defer os.Remove(configFile)
+
+	var stdout, stderr bytes.Buffer
+	code := runAppWithIO(strings.NewReader(""), &stdout, &stderr, "--config", configFile)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "# BEGIN MANAGED BLOCK\nblock 1\n# END MANAGED BLOCK\n# BEGIN 2 MANAGED BLOCK\nblock 2\n# END 2 MANAGED BLOCK\n", stdout.String())
+	assert.Equal(t, "-: block inserted\n-: block inserted\n", stderr.String())
+}
+
+func TestGlobPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glob_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
+	defer os.RemoveAll(dir)
+	writeTree(dir, "a.conf", "sub/b.conf", "sub/c.txt")
 
-	// Test setting owner
-	err = applyOwnership(f.Name(), "root", "")
+	out, code := runApp("--path", dir, "--recursive", "--include", "*.conf", "--block", "test block", "--changed-exit-code", "100")
+	assert.Equal(t, 100, code)
+	assert.Equal(t, dir+"/a.conf: block inserted\n"+dir+"/sub/b.conf: block inserted\n", out)
+	actual, err := ioutil.ReadFile(dir + "/sub/c.txt")
 	assert.NoError(t, err)
+	assert.Empty(t, actual)
 
-	// Test setting group
-	err = applyOwnership(f.Name(), "", "root")
-	assert.NoError(t, err)
+	out, code = runApp("--path", dir+"/*.conf", "--block", "test block", "--changed-exit-code", "100")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, dir+"/a.conf: unchanged\n", out)
 
-	// Test setting both
-	err = applyOwnership(f.Name(), "root", "root")
-	assert.NoError(t, err)
-}
+	out, code = runApp("--path", dir+"/*.ini", "--block", "test block")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, dir+"/*.ini: no files matched\n", out)
 
-func TestFileWithModeOwnerGroup(t *testing.T) {
-	// This test requires running as root for owner/group changes
-	if os.Geteuid() != 0 {
-		t.Skip("Skipping test that requires root privileges")
-	}
+	out, code = runApp("--path", dir+"/*.ini", "--block", "test block", "--output", "json")
+	assert.Equal(t, 0, code)
+	assert.JSONEq(t, `{"changed": false, "msg": "All items completed", "results": []}`, out)
+}
 
-	var origText = `line 1
-line 2
-`
-	var expected = `line 1
-line 2
-# BEGIN MANAGED BLOCK
-test block
-# END MANAGED BLOCK
-`
-	f, err := ioutil.TempFile("", "full_test")
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = f.WriteString(origText)
+func TestJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs_test")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
+	defer os.RemoveAll(dir)
 
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "test block",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         f.Name(),
-		Mode:         "0644",
-		Owner:        "root",
-		Group:        "root",
+	var entries []options
+	var expected string
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("%s/%02d.txt", dir, i)
+		entries = append(entries,
+			options{Path: path, Block: "block 1", Marker: "# {mark} ONE", MarkerBegin: "BEGIN", MarkerEnd: "END", State: "true", Create: "true"},
+			options{Path: path, Block: "block 2", Marker: "# {mark} TWO", MarkerBegin: "BEGIN", MarkerEnd: "END", State: "true", Create: "true"})
+		expected += path + ": block inserted\n" + path + ": block inserted\n"
 	}
 
-	updateBlockInFile(config)
-
-	// Check content
-	actual, err := ioutil.ReadFile(config.Path)
-	assert.NoError(t, err)
-	compare(t, expected, string(actual))
+	results := applyEntries(entries, false, 4)
+	var out bytes.Buffer
+	assert.NoError(t, printResults(&out, outputText, true, results))
+	assert.Equal(t, expected, out.String())
+	for i := 0; i < 20; i++ {
+		actual, err := ioutil.ReadFile(fmt.Sprintf("%s/%02d.txt", dir, i))
+		assert.NoError(t, err)
+		assert.Equal(t, "# BEGIN ONE\nblock 1\n# END ONE\n# BEGIN TWO\nblock 2\n# END TWO\n", string(actual))
+	}
 
-	// Check permissions
-	info, err := os.Stat(f.Name())
-	assert.NoError(t, err)
-	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	_, code := runApp("--path", dir+"/*.txt", "--block", "block 1", "--jobs", "0")
+	assert.Equal(t, exitInvalidFlags, code)
 }
 
-func TestFileWithModeOnly(t *testing.T) {
-	var origText = `line 1
-line 2
-`
-	var expected = `line 1
-line 2
-# BEGIN MANAGED BLOCK
-test block
-# END MANAGED BLOCK
-`
-	f, err := ioutil.TempFile("", "mode_only_test")
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = f.WriteString(origText)
+func TestNewline(t *testing.T) {
+	f, err := ioutil.TempFile("", "newline_test")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 	defer os.Remove(f.Name())
-
-	config := Config{
-		Backup:       false,
-		State:        true,
-		Indent:       0,
-		Block:        "test block",
-		InsertBefore: "",
-		InsertAfter:  "",
-		BeginMarker:  "# BEGIN MANAGED BLOCK",
-		EndMarker:    "# END MANAGED BLOCK",
-		Path:         f.Name(),
-		Mode:         "0600",
-		Owner:        "",
-		Group:        "",
+	if _, err := f.WriteString("line 1\r\n"); err != nil {
+		log.Fatal(err)
 	}
 
-	updateBlockInFile(config)
-
-	// Check content
-	actual, err := ioutil.ReadFile(config.Path)
+	_, code := runApp("--path", f.Name(), "--block", "test block")
+	assert.Equal(t, 0, code)
+	actual, err := ioutil.ReadFile(f.Name())
 	assert.NoError(t, err)
-	compare(t, expected, string(actual))
+	assert.Equal(t, "line 1\r\n# BEGIN MANAGED BLOCK\r\ntest block\r\n# END MANAGED BLOCK\r\n", string(actual))
 
-	// Check permissions
-	info, err := os.Stat(f.Name())
-	assert.NoError(t, err)
-	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	out, code := runApp("--path", f.Name(), "--block", "test block", "--newline", "cr")
+	assert.Equal(t, exitInvalidFlags, code)
+	assert.Contains(t, out, blockinfile.ErrInvalidNewline.Error())
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+)
+
+// Supported values of the output flag
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// entryResult is the outcome of applying one block to a file
+type entryResult struct {
+	path   string
+	check  bool
+	result blockinfile.Result
+	err    error
+}
+
+// jsonResult mirrors the return values of Ansible's blockinfile module
+type jsonResult struct {
+	Path       string                        `json:"path"`
+	Changed    bool                          `json:"changed"`
+	Failed     bool                          `json:"failed,omitempty"`
+	Action     blockinfile.Action            `json:"action"`
+	Msg        string                        `json:"msg"`
+	BackupFile string                        `json:"backup_file,omitempty"`
+	Attributes []blockinfile.AttributeChange `json:"attributes,omitempty"`
+	Diff       string                        `json:"diff,omitempty"`
+}
+
+// jsonLoopResult mirrors the return values of an Ansible task looping over several items
+type jsonLoopResult struct {
+	Changed bool         `json:"changed"`
+	Failed  bool         `json:"failed,omitempty"`
+	Msg     string       `json:"msg"`
+	Results []jsonResult `json:"results"`
+}
+
+// printResults writes the results of a run in the requested output format.
+// With summary, e.g. for a blocks list, every entry is reported instead of a single result.
+func printResults(w io.Writer, output string, summary bool, results []entryResult) error {
+	if output == outputJSON {
+		if !summary {
+			return writeJSON(w, newJSONResult(results[0]))
+		}
+		loop := jsonLoopResult{Changed: anyChanged(results), Msg: "All items completed", Results: []jsonResult{}}
+		for _, r := range results {
+			loop.Results = append(loop.Results, newJSONResult(r))
+			if r.err != nil {
+				loop.Failed = true
+				loop.Msg = "One or more items failed"
+			}
+		}
+		return writeJSON(w, loop)
+	}
+
+	for _, r := range results {
+		if r.err != nil {
+			if summary {
+				fmt.Fprintf(w, "%s: failed: %v\n", r.path, r.err)
+			}
+			continue
+		}
+		fmt.Fprint(w, r.result.Diff)
+		if summary || r.check {
+			fmt.Fprintln(w, resultReport(r.path, r.check, r.result))
+		}
+	}
+	return nil
+}
+
+func newJSONResult(r entryResult) jsonResult {
+	if r.err != nil {
+		return jsonResult{Path: r.path, Failed: true, Msg: r.err.Error()}
+	}
+	return jsonResult{
+		Path:       r.path,
+		Changed:    r.result.Changed,
+		Action:     r.result.Action,
+		Msg:        resultMessage(r.result),
+		BackupFile: r.result.BackupFile,
+		Attributes: r.result.Attributes,
+		Diff:       r.result.Diff,
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func anyChanged(results []entryResult) bool {
+	for _, r := range results {
+		if r.err == nil && r.result.Changed {
+			return true
+		}
+	}
+	return false
+}
+
+// resultMessage returns the msg Ansible's blockinfile module would report for result
+func resultMessage(result blockinfile.Result) string {
+	var msg string
+	switch result.Action {
+	case blockinfile.ActionInserted:
+		msg = "Block inserted"
+	case blockinfile.ActionReplaced:
+		msg = "Block replaced"
+	case blockinfile.ActionRemoved:
+		msg = "Block removed"
+	case blockinfile.ActionRestored:
+		return "File restored from backup"
+	}
+
+	if len(result.Attributes) > 0 {
+		if msg == "" {
+			return "ownership, perms or SE linux context changed"
+		}
+		msg += " and ownership, perms or SE linux context changed"
+	}
+	return msg
+}
+
+// resultReport describes in one line what happened to path, or would happen in check mode
+func resultReport(path string, check bool, result blockinfile.Result) string {
+	switch {
+	case !result.Changed:
+		return path + ": unchanged"
+	case result.Action == blockinfile.ActionUnchanged && check:
+		return path + ": attributes would be changed"
+	case result.Action == blockinfile.ActionUnchanged:
+		return path + ": attributes changed"
+	case check:
+		return fmt.Sprintf("%s: block would be %s", path, result.Action)
+	default:
+		return fmt.Sprintf("%s: block %s", path, result.Action)
+	}
+}
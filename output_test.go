@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultReport(t *testing.T) {
+	assert.Equal(t, "/tmp/file: unchanged", resultReport("/tmp/file", true, blockinfile.Result{}))
+	assert.Equal(t, "/tmp/file: block would be inserted",
+		resultReport("/tmp/file", true, blockinfile.Result{Changed: true, Action: blockinfile.ActionInserted}))
+	assert.Equal(t, "/tmp/file: block removed",
+		resultReport("/tmp/file", false, blockinfile.Result{Changed: true, Action: blockinfile.ActionRemoved}))
+	assert.Equal(t, "/tmp/file: attributes would be changed",
+		resultReport("/tmp/file", true, blockinfile.Result{Changed: true, Action: blockinfile.ActionUnchanged}))
+}
+
+func TestResultMessage(t *testing.T) {
+	assert.Equal(t, "", resultMessage(blockinfile.Result{}))
+	assert.Equal(t, "Block inserted", resultMessage(blockinfile.Result{Changed: true, Action: blockinfile.ActionInserted}))
+	assert.Equal(t, "Block removed", resultMessage(blockinfile.Result{Changed: true, Action: blockinfile.ActionRemoved}))
+	assert.Equal(t, "ownership, perms or SE linux context changed", resultMessage(blockinfile.Result{
+		Attributes: []blockinfile.AttributeChange{{Name: "mode", Before: "0644", After: "0600"}},
+	}))
+	assert.Equal(t, "Block replaced and ownership, perms or SE linux context changed", resultMessage(blockinfile.Result{
+		Changed:    true,
+		Action:     blockinfile.ActionReplaced,
+		Attributes: []blockinfile.AttributeChange{{Name: "owner", Before: "root", After: "nobody"}},
+	}))
+}
+
+func TestPrintResultJSON(t *testing.T) {
+	var expected = `{
+  "path": "/tmp/file",
+  "changed": true,
+  "action": "inserted",
+  "msg": "Block inserted and ownership, perms or SE linux context changed",
+  "backup_file": "/tmp/file.20240102T030405.000000000Z",
+  "attributes": [
+    {
+      "name": "mode",
+      "before": "0644",
+      "after": "0600"
+    }
+  ]
+}
+`
+	var out bytes.Buffer
+	err := printResults(&out, outputJSON, false, []entryResult{{
+		path: "/tmp/file",
+		result: blockinfile.Result{
+			Changed:    true,
+			Action:     blockinfile.ActionInserted,
+			BackupFile: "/tmp/file.20240102T030405.000000000Z",
+			Attributes: []blockinfile.AttributeChange{{Name: "mode", Before: "0644", After: "0600"}},
+		},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, out.String())
+}
+
+func TestPrintResultText(t *testing.T) {
+	var out bytes.Buffer
+	err := printResults(&out, outputText, false, []entryResult{{
+		path:   "/tmp/file",
+		result: blockinfile.Result{Changed: true, Action: blockinfile.ActionInserted},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", out.String())
+
+	err = printResults(&out, outputText, false, []entryResult{{
+		path:   "/tmp/file",
+		check:  true,
+		result: blockinfile.Result{Changed: true, Action: blockinfile.ActionRemoved},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/file: block would be removed\n", out.String())
+}
+
+func TestPrintResultsSummary(t *testing.T) {
+	results := []entryResult{
+		{path: "/tmp/a", result: blockinfile.Result{Changed: true, Action: blockinfile.ActionInserted}},
+		{path: "/tmp/b", result: blockinfile.Result{}},
+		{path: "/tmp/c", err: blockinfile.ErrMissingPath},
+	}
+
+	var out bytes.Buffer
+	err := printResults(&out, outputText, true, results)
+	assert.NoError(t, err)
+	assert.Equal(t, `/tmp/a: block inserted
+/tmp/b: unchanged
+/tmp/c: failed: required flag "path" not set
+`, out.String())
+
+	out.Reset()
+	err = printResults(&out, outputJSON, true, results)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "changed": true,
+  "failed": true,
+  "msg": "One or more items failed",
+  "results": [
+    {
+      "path": "/tmp/a",
+      "changed": true,
+      "action": "inserted",
+      "msg": "Block inserted"
+    },
+    {
+      "path": "/tmp/b",
+      "changed": false,
+      "action": "unchanged",
+      "msg": ""
+    },
+    {
+      "path": "/tmp/c",
+      "changed": false,
+      "failed": true,
+      "action": "unchanged",
+      "msg": "required flag \"path\" not set"
+    }
+  ]
+}
+`, out.String())
+}
@@ -0,0 +1,242 @@
+package blockinfile
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AttributeChange records a mode, owner or group change of a file.
+type AttributeChange struct {
+	Name   string `json:"name"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// fileAttributes holds the mode, owner and group of a file, where mode holds the permission bits
+type fileAttributes struct {
+	mode         os.FileMode
+	owner, group string
+}
+
+// readFileAttributes returns the current mode, owner and group of the file
+func readFileAttributes(path string) (fileAttributes, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileAttributes{}, err
+	}
+
+	attributes := fileAttributes{mode: info.Mode() & permissionBits}
+	if uid, gid, ok := fileOwner(info); ok {
+		attributes.owner = userName(strconv.Itoa(uid))
+		attributes.group = groupName(strconv.Itoa(gid))
+	}
+	return attributes, nil
+}
+
+// plannedFileAttributes returns the attributes the file would have after applying config, whose mode
+// was checked by checkConfig
+func plannedFileAttributes(current fileAttributes, config Config) fileAttributes {
+	planned := current
+	if mode, err := parseMode(config.Mode, current.mode); config.Mode != "" && err == nil {
+		planned.mode = mode
+	}
+	if config.Owner != "" {
+		planned.owner = userName(config.Owner)
+	}
+	if config.Group != "" {
+		planned.group = groupName(config.Group)
+	}
+	return planned
+}
+
+// attributeChanges returns a change for each attribute that differs between before and after
+func attributeChanges(before, after fileAttributes) []AttributeChange {
+	var changes []AttributeChange
+	if before.mode != after.mode {
+		changes = append(changes, AttributeChange{
+			Name:   "mode",
+			Before: fmt.Sprintf("%04o", unixMode(before.mode)),
+			After:  fmt.Sprintf("%04o", unixMode(after.mode)),
+		})
+	}
+	if before.owner != after.owner {
+		changes = append(changes, AttributeChange{Name: "owner", Before: before.owner, After: after.owner})
+	}
+	if before.group != after.group {
+		changes = append(changes, AttributeChange{Name: "group", Before: before.group, After: after.group})
+	}
+	return changes
+}
+
+// attributeDiff returns before/after metadata lines for each attribute change
+func attributeDiff(changes []AttributeChange) string {
+	var sb strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&sb, "old %s %s\nnew %s %s\n", change.Name, change.Before, change.Name, change.After)
+	}
+	return sb.String()
+}
+
+// userName resolves a numeric user ID to its name, so IDs and names compare equal
+func userName(owner string) string {
+	if _, err := strconv.Atoi(owner); err != nil {
+		return owner
+	}
+	if u, err := user.LookupId(owner); err == nil {
+		return u.Username
+	}
+	return owner
+}
+
+// groupName resolves a numeric group ID to its name, so IDs and names compare equal
+func groupName(group string) string {
+	if _, err := strconv.Atoi(group); err != nil {
+		return group
+	}
+	if g, err := user.LookupGroupId(group); err == nil {
+		return g.Name
+	}
+	return group
+}
+
+// applyFileAttributes applies mode, owner, and group settings to the file
+func applyFileAttributes(path string, config Config) error {
+	// Apply owner and group
+	if config.Owner != "" || config.Group != "" {
+		if err := applyOwnership(path, config.Owner, config.Group); err != nil {
+			return err
+		}
+	}
+
+	// Apply mode (permissions)
+	if config.Mode != "" {
+		if err := applyMode(path, config.Mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyOwnership changes the owner and/or group of the file, given by name or numeric ID.
+// Like chown, it changes the file a symlink points to, and only when they differ.
+func applyOwnership(path, owner, group string) error {
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := lookupUID(owner)
+		if err != nil {
+			return fmt.Errorf("failed to change ownership: %w", err)
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := lookupGID(group)
+		if err != nil {
+			return fmt.Errorf("failed to change ownership: %w", err)
+		}
+		gid = id
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("failed to change ownership: %w", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("failed to change ownership: %w", err)
+	}
+	// Changing the owner clears the setuid and setgid bits, even when it is the same owner
+	if currentUID, currentGID, ok := fileOwner(info); ok && (uid < 0 || uid == currentUID) && (gid < 0 || gid == currentGID) {
+		return nil
+	}
+	if err := os.Lchown(target, uid, gid); err != nil {
+		return fmt.Errorf("failed to change ownership: %w", err)
+	}
+	return nil
+}
+
+// checkFileAttributes checks that the modes of config parse and that its owners and groups exist, so
+// they fail before any file is touched
+func checkFileAttributes(config Config) error {
+	for _, mode := range []string{config.Mode, config.DirMode} {
+		if mode != "" {
+			if _, err := parseMode(mode, defaultFileMode); err != nil {
+				return err
+			}
+		}
+	}
+	for _, owner := range []string{config.Owner, config.DirOwner} {
+		if owner != "" {
+			if _, err := lookupUID(owner); err != nil {
+				return fmt.Errorf("%w %q: %w", ErrInvalidOwner, owner, err)
+			}
+		}
+	}
+	for _, group := range []string{config.Group, config.DirGroup} {
+		if group != "" {
+			if _, err := lookupGID(group); err != nil {
+				return fmt.Errorf("%w %q: %w", ErrInvalidGroup, group, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupUID returns the numeric ID of a user given by name or ID
+func lookupUID(owner string) (int, error) {
+	return lookupID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+}
+
+// lookupGID returns the numeric ID of a group given by name or ID
+func lookupGID(group string) (int, error) {
+	return lookupID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+}
+
+// lookupID returns the numeric ID of a user or group given by name or ID, looking up names with lookup
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// applyMode changes the file permissions to an octal or symbolic mode, only when they differ
+func applyMode(path, mode string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+	fileMode, err := parseMode(mode, info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+	if fileMode == info.Mode()&permissionBits {
+		return nil
+	}
+
+	if err := os.Chmod(path, fileMode); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package blockinfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner and group of the file described by info
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
@@ -0,0 +1,107 @@
+package blockinfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backupTimeFormat is the UTC timestamp appended to backup files. It has no colons, so it is
+// safe in file names everywhere, and sorts in time order.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backupPath returns the path of a new backup of path. With a backup dir, the absolute path of the
+// file is mirrored below it, so files with the same name in different directories do not clash.
+func backupPath(path, backupDir string, now time.Time) (string, error) {
+	backup := path
+	if backupDir != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		backup = filepath.Join(backupDir, abs[len(filepath.VolumeName(abs)):])
+	}
+	return backup + "." + now.UTC().Format(backupTimeFormat), nil
+}
+
+// backupFile copies sourceFile, with its mode, owner, group and extended attributes, to a new backup
+// and returns the path of the copy
+func backupFile(sourceFile string, config Config) (string, error) {
+	input, err := ioutil.ReadFile(sourceFile)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+	info, err := os.Stat(sourceFile)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+
+	backup, err := backupPath(sourceFile, config.BackupDir, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBackupFailed, err)
+	}
+	if config.BackupDir != "" {
+		if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrBackupFailed, err)
+		}
+	}
+	if err := writeBackup(backup, input, info, sourceFile); err != nil {
+		os.Remove(backup)
+		return "", fmt.Errorf("%w: %w", ErrBackupFailed, err)
+	}
+	return backup, nil
+}
+
+// writeBackup creates backup with content, readable by nobody until it has the attributes of the source
+func writeBackup(backup string, content []byte, info os.FileInfo, sourceFile string) error {
+	f, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Keep the owner on a best effort basis, since only root may give a file away
+	if uid, gid, ok := fileOwner(info); ok {
+		os.Lchown(backup, uid, gid)
+	}
+	if err := os.Chmod(backup, info.Mode()&permissionBits); err != nil {
+		return err
+	}
+	return copyXattrs(sourceFile, backup)
+}
+
+// pruneBackups removes all but the newest keep backups of path. Without keep, all backups are kept.
+func pruneBackups(path string, config Config) error {
+	if config.BackupKeep <= 0 {
+		return nil
+	}
+
+	backups, err := ListBackups(path, config.BackupDir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupFailed, err)
+	}
+	for i := config.BackupKeep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return fmt.Errorf("%w: %w", ErrBackupFailed, err)
+		}
+	}
+	return nil
+}
+
+// parseBackupTime parses the timestamp of a backup, including the RFC 3339 ones of older versions
+func parseBackupTime(s string) (time.Time, bool) {
+	for _, layout := range []string{backupTimeFormat, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,108 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupPath(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600))
+
+	backup, err := backupPath("/etc/hosts", "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/hosts.20240102T020405.000000006Z", backup)
+
+	backup, err = backupPath("/etc/hosts", "/var/backups", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/backups/etc/hosts.20240102T020405.000000006Z", backup)
+}
+
+func TestBackupFileKeepsAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secret.conf")
+	if err := ioutil.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		log.Fatal(err)
+	}
+	os.Chmod(path, 0640)
+
+	backupDir := filepath.Join(dir, "backups")
+	backup, err := backupFile(path, Config{BackupDir: backupDir})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(backupDir, path), backup[:len(backup)-len(".20240102T030405.000000000Z")])
+
+	actual, err := ioutil.ReadFile(backup)
+	assert.NoError(t, err)
+	compare(t, "secret\n", string(actual))
+
+	info, err := os.Stat(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	before, err := readFileAttributes(path)
+	assert.NoError(t, err)
+	after, err := readFileAttributes(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestApplyFileBackupKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	// Neither unrelated files nor files of similar names are pruned
+	for _, name := range []string{"hosts.allow", "hosts.2024-01-02T03:04:05Z", "other.20240102T030405.000000000Z"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	config := Config{
+		Backup:      true,
+		BackupKeep:  2,
+		State:       true,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	var backups []string
+	for _, block := range []string{"one", "two", "three"} {
+		config.Block = block
+		result, err := ApplyFile(path, config)
+		assert.NoError(t, err)
+		assert.FileExists(t, result.BackupFile)
+		backups = append(backups, result.BackupFile)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{
+		"hosts",
+		"hosts.allow",
+		"other.20240102T030405.000000000Z",
+		filepath.Base(backups[1]),
+		filepath.Base(backups[2]),
+	}, names)
+}
+
+func TestApplyBackupKeepNegative(t *testing.T) {
+	_, _, err := Apply("", Config{State: true, BackupKeep: -1})
+	assert.ErrorIs(t, err, ErrInvalidBackupKeep)
+}
@@ -0,0 +1,355 @@
+// Package blockinfile inserts, updates or removes a block of multi-line text
+// surrounded by customizable marker lines.
+package blockinfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config describes the block to manage and how it is placed in the content.
+type Config struct {
+	// Block is the text between the markers.
+	Block string
+	// Indent is the number of spaces each line of the block is indented by.
+	Indent int
+	// BeginMarker and EndMarker are the lines surrounding the block.
+	BeginMarker, EndMarker string
+	// State is whether the block should be there or not.
+	State bool
+
+	// InsertBefore and InsertAfter place a new block relative to a line matching the regular
+	// expression. "BOF" and "EOF" place it at the beginning or end of the file.
+	InsertBefore, InsertAfter string
+	// Literal matches InsertBefore and InsertAfter as plain substrings instead.
+	Literal bool
+	// FirstMatch anchors on the first match instead of the last.
+	FirstMatch bool
+	// Newline is the line break of the markers and the block, "lf" or "crlf". By default, or with
+	// "auto", it is the most common one of the content. Markers are found whatever their line break.
+	Newline string
+
+	// Check only reports what ApplyFile would change, without touching the file.
+	Check bool
+	// Diff adds a unified diff of the change to the result.
+	Diff bool
+	// Validate is a command, e.g. "visudo -cf %s", that ApplyFile runs on a temp file with the new
+	// content, with %s replaced by its path. The file is only written when the command succeeds.
+	Validate string
+
+	// Backup writes a backup of the file before changing it.
+	Backup bool
+	// BackupDir is the directory to write backups to, below the absolute path of the file, instead of
+	// next to the file.
+	BackupDir string
+	// BackupKeep is the number of newest backups of the file to keep. 0 keeps all of them.
+	BackupKeep int
+
+	// MustExist makes a missing file an error when adding a block, instead of creating it.
+	MustExist bool
+	// CreateDirs creates the missing parent directories of a file that is created.
+	CreateDirs bool
+	// DirMode is the mode of the directories CreateDirs creates, octal or symbolic like Mode.
+	DirMode string
+	// DirOwner is the owner of the directories CreateDirs creates, by name or ID.
+	DirOwner string
+	// DirGroup is the group of the directories CreateDirs creates, by name or ID.
+	DirGroup string
+	// Mode, Owner and Group are the attributes of the file.
+	Mode, Owner, Group string
+
+	// UnsafeWrites rewrites the file in place when it cannot be replaced atomically.
+	UnsafeWrites bool
+	// LockTimeout is how long to wait for other processes to release the lock of the file, which is
+	// held while it is read and written. 0 waits indefinitely.
+	LockTimeout time.Duration
+}
+
+// Special values of InsertBefore and InsertAfter for the beginning and end of the file
+const (
+	beginningOfFile = "BOF"
+	endOfFile       = "EOF"
+)
+
+// Action is the operation performed on the content.
+type Action int
+
+const (
+	// ActionUnchanged means the content already matched and nothing was done.
+	ActionUnchanged Action = iota
+	// ActionInserted means the block did not exist and was added.
+	ActionInserted
+	// ActionReplaced means an existing block was rewritten.
+	ActionReplaced
+	// ActionRemoved means an existing block was deleted.
+	ActionRemoved
+	// ActionRestored means the file was restored from a backup.
+	ActionRestored
+)
+
+// MarshalText encodes the action by name, e.g. "inserted".
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a Action) String() string {
+	switch a {
+	case ActionInserted:
+		return "inserted"
+	case ActionReplaced:
+		return "replaced"
+	case ActionRemoved:
+		return "removed"
+	case ActionRestored:
+		return "restored"
+	default:
+		return "unchanged"
+	}
+}
+
+// Result reports what Apply, ApplyFile or Restore did. Apply sets neither BackupFile nor Attributes.
+type Result struct {
+	Changed    bool
+	Action     Action
+	BackupFile string
+	Attributes []AttributeChange
+	Diff       string
+}
+
+// Apply returns content with the block inserted, replaced or removed according to config.
+func Apply(content string, config Config) (string, Result, error) {
+	if err := checkConfig(config); err != nil {
+		return content, Result{}, err
+	}
+
+	updatedContent := replaceTextBetweenMarkers(content, config)
+	result := newResult(content, updatedContent, config)
+	if config.Diff {
+		result.Diff = unifiedDiff("before", "after", content, updatedContent)
+	}
+	return updatedContent, result, nil
+}
+
+func checkConfig(config Config) error {
+	if config.InsertBefore != "" && config.InsertAfter != "" {
+		return ErrConflictingInsertFlags
+	}
+	if config.Indent < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidIndent, config.Indent)
+	}
+	if config.BackupKeep < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidBackupKeep, config.BackupKeep)
+	}
+	if config.LockTimeout < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidLockTimeout, config.LockTimeout)
+	}
+	if config.Newline != "" && config.Newline != newlineAuto && config.Newline != newlineLF && config.Newline != newlineCRLF {
+		return fmt.Errorf("%w: %q", ErrInvalidNewline, config.Newline)
+	}
+	if config.Validate != "" && !strings.Contains(config.Validate, "%s") {
+		return fmt.Errorf("%w: %q must contain %%s", ErrInvalidValidateCommand, config.Validate)
+	}
+	if config.Validate != "" {
+		if _, err := splitCommand(config.Validate); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidValidateCommand, err)
+		}
+	}
+	if err := checkFileAttributes(config); err != nil {
+		return err
+	}
+	if !config.Literal {
+		for _, pattern := range []string{config.InsertBefore, config.InsertAfter} {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidPattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newResult(content, updatedContent string, config Config) Result {
+	result := Result{Changed: content != updatedContent}
+	switch {
+	case !result.Changed:
+		result.Action = ActionUnchanged
+	case !config.State:
+		result.Action = ActionRemoved
+	case lastMarkerLine(content, config.BeginMarker) >= 0:
+		result.Action = ActionReplaced
+	default:
+		result.Action = ActionInserted
+	}
+	return result
+}
+
+// lastMarkerLine returns the index of the last marker followed by a line break, whatever its style, or -1
+func lastMarkerLine(sourceText, marker string) int {
+	// Match the line break because markers could have similar prefix, it makes sure to match to end of line
+	index := strings.LastIndex(sourceText, marker+"\n")
+	if i := strings.LastIndex(sourceText, marker+"\r"); i > index {
+		index = i
+	}
+	return index
+}
+
+// lineBreakLen returns the length of the line break at the start of text, 0 if there is none
+func lineBreakLen(text string) int {
+	switch {
+	case strings.HasPrefix(text, "\r\n"):
+		return 2
+	case strings.HasPrefix(text, "\n"), strings.HasPrefix(text, "\r"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func endsWithLineBreak(text string) bool {
+	return strings.HasSuffix(text, "\n") || strings.HasSuffix(text, "\r")
+}
+
+func removeExistingBlock(sourceText, beginMarker, endMarker string) string {
+	beginIndex := lastMarkerLine(sourceText, beginMarker)
+	if beginIndex >= 0 {
+		sourceText = removeLeadingSpacesOfBlock(sourceText, beginIndex)
+		// After removing leading spaces, reset beginIndex
+		beginIndex := lastMarkerLine(sourceText, beginMarker)
+
+		// The end marker may be the last line without a line break
+		endIndex := strings.LastIndex(sourceText, endMarker) + len(endMarker)
+		endIndex += lineBreakLen(sourceText[endIndex:])
+		return sourceText[:beginIndex] + sourceText[endIndex:]
+	}
+	return sourceText
+}
+
+func removeLeadingSpacesOfBlock(sourceText string, beginIndex int) string {
+	// Remove any leading spaces of block
+	beginNonSpaceIndex := beginIndex
+	for nonSpaceIndex := beginIndex - 1; nonSpaceIndex >= 0; nonSpaceIndex-- {
+		if sourceText[nonSpaceIndex] != ' ' {
+			break
+		}
+		beginNonSpaceIndex = nonSpaceIndex
+	}
+	sourceText = sourceText[:beginNonSpaceIndex] + sourceText[beginIndex:]
+	return sourceText
+}
+
+// findMatchingLine returns the offsets of the start and end of the last line matching pattern,
+// or the first one with firstMatch, where the end includes the line break. Both are -1 when no line matches.
+func findMatchingLine(sourceText, pattern string, firstMatch bool) (int, int) {
+	re := regexp.MustCompile(pattern)
+	start, end := -1, -1
+	for lineStart := 0; lineStart < len(sourceText); {
+		lineEnd, textEnd := len(sourceText), len(sourceText)
+		if i := strings.IndexAny(sourceText[lineStart:], "\r\n"); i >= 0 {
+			textEnd = lineStart + i
+			lineEnd = textEnd + lineBreakLen(sourceText[textEnd:])
+		}
+		if re.MatchString(sourceText[lineStart:textEnd]) {
+			start, end = lineStart, lineEnd
+			if firstMatch {
+				break
+			}
+		}
+		lineStart = lineEnd
+	}
+	return start, end
+}
+
+// findSubstring returns the index of the last occurrence of substr, or the first one with firstMatch
+func findSubstring(sourceText, substr string, firstMatch bool) int {
+	if firstMatch {
+		return strings.Index(sourceText, substr)
+	}
+	return strings.LastIndex(sourceText, substr)
+}
+
+func replaceTextBetweenMarkers(sourceText string, config Config) string {
+	nl := newline(sourceText, config)
+	reAddSpaces := regexp.MustCompile(`\r?\n`)
+	paddedBeginMarker := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent), config.BeginMarker)
+	paddedEndMarker := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent), config.EndMarker)
+	paddedReplaceText := fmt.Sprintf("%s%s", strings.Repeat(" ", config.Indent),
+		reAddSpaces.ReplaceAllLiteralString(config.Block, nl+strings.Repeat(" ", config.Indent)))
+	// The block with its markers, without a line break after the end marker
+	block := paddedBeginMarker + nl + paddedReplaceText + nl + paddedEndMarker
+
+	switch {
+	case !config.State:
+		return removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
+	case config.InsertBefore == beginningOfFile:
+		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
+
+		// Insert at BOF
+		return block + nl + sourceText
+	case config.InsertAfter == endOfFile:
+		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
+
+		// Insert at EOF, even when the block existed elsewhere
+		if sourceText != "" && !endsWithLineBreak(sourceText) {
+			sourceText += nl
+		}
+		return sourceText + block + nl
+	case config.InsertBefore != "":
+		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
+
+		var index = findSubstring(sourceText, config.InsertBefore, config.FirstMatch)
+		if !config.Literal {
+			index, _ = findMatchingLine(sourceText, config.InsertBefore, config.FirstMatch)
+		}
+		// Not found, insert at EOF
+		if index < 0 {
+			return sourceText + block + nl
+		}
+		// Insert before
+		return sourceText[:index] + block + nl + sourceText[index:]
+	case config.InsertAfter != "":
+		sourceText = removeExistingBlock(sourceText, config.BeginMarker, config.EndMarker)
+
+		if !config.Literal {
+			_, lineEnd := findMatchingLine(sourceText, config.InsertAfter, config.FirstMatch)
+			// Not found, insert at EOF
+			if lineEnd < 0 {
+				return sourceText + block + nl
+			}
+			if lineEnd == len(sourceText) && !endsWithLineBreak(sourceText) {
+				// The matching line is the last line and has no line break to insert after
+				sourceText += nl
+				lineEnd += len(nl)
+			}
+			// Insert after the matching line
+			return sourceText[:lineEnd] + block + nl + sourceText[lineEnd:]
+		}
+
+		var index = findSubstring(sourceText, config.InsertAfter, config.FirstMatch)
+		// Not found, insert at EOF
+		if index < 0 {
+			return sourceText + block + nl
+		}
+		// Insert after
+		index = index + len(config.InsertAfter)
+		return sourceText[:index] + nl + block + sourceText[index:]
+	case lastMarkerLine(sourceText, config.BeginMarker) >= 0:
+		// Remove any leading spaces before replacing the block in case indentation changed
+		beginIndex := lastMarkerLine(sourceText, config.BeginMarker)
+		sourceText = removeLeadingSpacesOfBlock(sourceText, beginIndex)
+
+		// Replace existing block
+		reReplaceMarker := regexp.MustCompile(fmt.Sprintf(`(?s)%s(?:\r\n|\n|\r)(.*?)%s(\r\n|\n|\r)?`,
+			regexp.QuoteMeta(config.BeginMarker), regexp.QuoteMeta(config.EndMarker)))
+		return reReplaceMarker.ReplaceAllStringFunc(sourceText, func(match string) string {
+			// Give the line break after the end marker the new style as well
+			if endsWithLineBreak(match) {
+				return block + nl
+			}
+			return block
+		})
+	default:
+		// Not found, add to EOF
+		return sourceText + block + nl
+	}
+}
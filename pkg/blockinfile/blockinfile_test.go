@@ -0,0 +1,991 @@
+package blockinfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func compare(t *testing.T, expected string, actual string) {
+	if expected != actual {
+		dmp := diffmatchpatch.New()
+		diffs := dmp.DiffMain(actual, expected, false)
+		t.Errorf("The differences are:%s", dmp.DiffPrettyText(diffs))
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	var origText = ""
+	var expected = `# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+
+	if expected != replaceTextBetweenMarkers(origText, config) {
+		t.Error("block should have been added to EOF")
+	}
+}
+
+func TestNotFindTextToReplace(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+`
+	var expected = `
+line 1
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+pattern not exist before
+# END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "pattern not exist before",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestFindOneMatchToReplace(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+line 4
+line 5
+`
+	var expected = `
+line 1
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+line 4
+line 5
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestDollarSignToReplace(t *testing.T) {
+	var origText = `
+line 1
+$USER
+$20
+line 2
+line 3
+#!/bin/bash
+$USER1 original block of text $VAR1
+# managed file end
+line 4
+line 5
+`
+	var expected = `
+line 1
+$USER
+$20
+line 2
+line 3
+#!/bin/bash
+$1 swapped with $VAR2 lorem ipsum. $$$ lorem ipsum. Echo $USER
+# managed file end
+line 4
+line 5
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "$1 swapped with $VAR2 lorem ipsum. $$$ lorem ipsum. Echo $USER",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "#!/bin/bash",
+		EndMarker:    "# managed file end",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestNoIndentToWithIndent(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+`
+	var expected = `
+line 1
+line 2
+    # BEGIN MANAGED BLOCK
+    swapped with me
+    # END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestWithIndentToNoIndent(t *testing.T) {
+	var origText = `
+line 1
+line 2
+    # BEGIN MANAGED BLOCK
+    original block of text
+    # END MANAGED BLOCK
+`
+	var expected = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	var actual = replaceTextBetweenMarkers(origText, config)
+	compare(t, expected, actual)
+}
+
+func TestFindMultipleMatchToReplace(t *testing.T) {
+	var origText = `
+line 1
+# BEGIN MANAGED BLOCK
+original block of text 1
+# END MANAGED BLOCK
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+original block of text 2
+# END MANAGED BLOCK
+line 4
+line 5
+`
+	var expected = `
+line 1
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+line 4
+line 5
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBlockSimilarPrefixMarker(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+`
+	var expected = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+# BEGIN MANAGED BLOCK - Common
+new block
+# END MANAGED BLOCK - Common
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "new block",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
+		EndMarker:    "# END MANAGED BLOCK - Common",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestReplaceBlockSimilarPrefixMarker(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+# BEGIN MANAGED BLOCK - Common
+new block
+# END MANAGED BLOCK - Common
+`
+	var expected = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+# BEGIN MANAGED BLOCK - Common
+replaced similar prefix block
+# END MANAGED BLOCK - Common
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "replaced similar prefix block",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
+		EndMarker:    "# END MANAGED BLOCK - Common",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestRemoveBlockSimilarPrefixMarker(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+# BEGIN MANAGED BLOCK - Common
+new block
+# END MANAGED BLOCK - Common
+`
+	var expected = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        false,
+		Indent:       0,
+		Block:        "new block",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
+		EndMarker:    "# END MANAGED BLOCK - Common",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBeforeExistingBlock(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+line 3
+`
+	var expected = `
+line 1
+    # BEGIN MANAGED BLOCK
+    swapped with me
+    # END MANAGED BLOCK
+line 2
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "swapped with me",
+		InsertBefore: "line 2",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBeforeNonExistingBlock(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+line 3
+`
+	var expected = `
+line 1
+line 2
+line 3
+    # BEGIN MANAGED BLOCK
+    swapped with me
+    # END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "swapped with me",
+		InsertBefore: "i do not exist",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBeforeBlockSimilarPrefixMarker(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+`
+	var expected = `
+    # BEGIN MANAGED BLOCK - Common
+    new block
+    # END MANAGED BLOCK - Common
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "new block",
+		InsertBefore: "line 1",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
+		EndMarker:    "# END MANAGED BLOCK - Common",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterBlockSimilarPrefixMarker(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+`
+	var expected = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK - Common Global
+original block of text
+# END MANAGED BLOCK - Common Global
+line 3
+    # BEGIN MANAGED BLOCK - Common
+    new block
+    # END MANAGED BLOCK - Common
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "new block",
+		InsertBefore: "",
+		InsertAfter:  "line 3",
+		BeginMarker:  "# BEGIN MANAGED BLOCK - Common",
+		EndMarker:    "# END MANAGED BLOCK - Common",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterExistingBlock(t *testing.T) {
+	var origText = `
+line 1
+line 2
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+line 3
+`
+	var expected = `
+line 1
+    # BEGIN MANAGED BLOCK
+    swapped with me
+    # END MANAGED BLOCK
+line 2
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "line 1",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterNoExistingBlock(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+`
+	var expected = `
+line 1
+line 2
+line 3
+    # BEGIN MANAGED BLOCK
+    swapped with me
+    # END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       4,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "line 3",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterNoExistingBlockNoMatchInsertAfter(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+`
+	var expected = `
+line 1
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+`
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "XXXX",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestStateIsFalseNoIndent(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+# BEGIN MANAGED BLOCK
+swapped with me
+# END MANAGED BLOCK
+`
+	var expected = `
+line 1
+line 2
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        false,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "XXXX",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestStateIsFalseWithIndent(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+      # BEGIN MANAGED BLOCK
+      swapped with me
+      # END MANAGED BLOCK
+`
+	var expected = `
+line 1
+line 2
+line 3
+`
+	config := Config{
+		Backup:       false,
+		State:        false,
+		Indent:       0,
+		Block:        "swapped with me",
+		InsertBefore: "XXXX",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestMultiLineBlock(t *testing.T) {
+	var origText = `
+# BEGIN MANAGED BLOCK
+  <?PHP
+    phpinfo();
+# END MANAGED BLOCK
+`
+	var expected = `
+# BEGIN MANAGED BLOCK
+  <?PHP
+    phpinfo();
+    # test
+# END MANAGED BLOCK
+`
+	config := Config{
+		Backup: false,
+		State:  true,
+		Indent: 0,
+		Block: "  <?PHP\n" +
+			"    phpinfo();\n" +
+			"    # test",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestMarkerWithStarAndAsterisk(t *testing.T) {
+	var origText = `
+#!/usr/bin/php -q
+  <?PHP
+    phpinfo(); $1 $USER1
+/* managed file end */ ?>
+`
+	var expected = `
+#!/usr/bin/php -q
+  <?PHP
+    phpinfo(); $1 $USER1
+    # test
+/* managed file end */ ?>
+`
+	config := Config{
+		Backup: false,
+		State:  true,
+		Indent: 0,
+		Block: "  <?PHP\n" +
+			"    phpinfo(); $1 $USER1\n" +
+			"    # test",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "#!/usr/bin/php -q",
+		EndMarker:    "/* managed file end */ ?>",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestApplyResultAction(t *testing.T) {
+	var withBlock = `line 1
+# BEGIN MANAGED BLOCK
+original block of text
+# END MANAGED BLOCK
+`
+	config := Config{
+		State:       true,
+		Block:       "swapped with me",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	_, result, err := Apply("line 1\n", config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Action: ActionInserted}, result)
+
+	updated, result, err := Apply(withBlock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Action: ActionReplaced}, result)
+
+	_, result, err = Apply(updated, config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: false, Action: ActionUnchanged}, result)
+
+	config.State = false
+	_, result, err = Apply(withBlock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Action: ActionRemoved}, result)
+
+	_, result, err = Apply("line 1\n", config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: false, Action: ActionUnchanged}, result)
+}
+
+func TestApplyConflictingInsertFlags(t *testing.T) {
+	config := Config{
+		State:        true,
+		Block:        "swapped with me",
+		InsertBefore: "line 1",
+		InsertAfter:  "line 2",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+
+	actual, _, err := Apply("line 1\nline 2\n", config)
+	assert.ErrorIs(t, err, ErrConflictingInsertFlags)
+	assert.Equal(t, "line 1\nline 2\n", actual)
+}
+
+func TestApplyNegativeIndent(t *testing.T) {
+	config := Config{
+		State:       true,
+		Indent:      -1,
+		Block:       "swapped with me",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	_, _, err := Apply("line 1\n", config)
+	assert.ErrorIs(t, err, ErrInvalidIndent)
+}
+
+func TestApplyNegativeLockTimeout(t *testing.T) {
+	config := Config{
+		State:       true,
+		LockTimeout: -time.Second,
+		Block:       "swapped with me",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	_, _, err := Apply("line 1\n", config)
+	assert.ErrorIs(t, err, ErrInvalidLockTimeout)
+}
+
+func TestInsertAfterRegex(t *testing.T) {
+	var origText = `[main]
+key = value
+[section]
+key = value
+[section.sub]
+key = value
+`
+	var expected = `[main]
+key = value
+[section]
+# BEGIN MANAGED BLOCK
+managed = true
+# END MANAGED BLOCK
+key = value
+[section.sub]
+key = value
+`
+	config := Config{
+		State:       true,
+		Block:       "managed = true",
+		InsertAfter: `^\[section\]$`,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBeforeRegex(t *testing.T) {
+	var origText = `line 1
+Match User alice
+line 2
+Match User bob
+line 3
+`
+	var expected = `line 1
+Match User alice
+line 2
+# BEGIN MANAGED BLOCK
+PasswordAuthentication no
+# END MANAGED BLOCK
+Match User bob
+line 3
+`
+	config := Config{
+		State:        true,
+		Block:        "PasswordAuthentication no",
+		InsertBefore: `^Match\s+User`,
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterRegexLastLineWithoutNewline(t *testing.T) {
+	var origText = `line 1
+line 2`
+	var expected = `line 1
+line 2
+# BEGIN MANAGED BLOCK
+new block
+# END MANAGED BLOCK
+`
+	config := Config{
+		State:       true,
+		Block:       "new block",
+		InsertAfter: `^line 2$`,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterLiteral(t *testing.T) {
+	var origText = `line 1
+value [x]
+line 2
+`
+	var expected = `line 1
+value [x]
+# BEGIN MANAGED BLOCK
+new block
+# END MANAGED BLOCK
+line 2
+`
+	config := Config{
+		Literal:     true,
+		State:       true,
+		Block:       "new block",
+		InsertAfter: "value [x]",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+
+	// As a regular expression [x] only matches the character x, so the block lands at EOF
+	config.Literal = false
+	compare(t, origText+"# BEGIN MANAGED BLOCK\nnew block\n# END MANAGED BLOCK\n", replaceTextBetweenMarkers(origText, config))
+}
+
+func TestApplyInvalidPattern(t *testing.T) {
+	config := Config{
+		State:        true,
+		Block:        "new block",
+		InsertBefore: `^[section$`,
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+
+	_, _, err := Apply("line 1\n", config)
+	assert.ErrorIs(t, err, ErrInvalidPattern)
+
+	config.Literal = true
+	_, _, err = Apply("line 1\n", config)
+	assert.NoError(t, err)
+}
+
+func TestInsertBeforeBOF(t *testing.T) {
+	var origText = `line 1
+# BEGIN MANAGED BLOCK
+old block
+# END MANAGED BLOCK
+line 2
+`
+	var expected = `  # BEGIN MANAGED BLOCK
+  new block
+  # END MANAGED BLOCK
+line 1
+line 2
+`
+	config := Config{
+		State:        true,
+		Indent:       2,
+		Block:        "new block",
+		InsertBefore: "BOF",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	actual := replaceTextBetweenMarkers(origText, config)
+	compare(t, expected, actual)
+
+	// Applying again is a no-op
+	compare(t, expected, replaceTextBetweenMarkers(actual, config))
+
+	// BOF is a keyword in literal mode too
+	config.Literal = true
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterEOF(t *testing.T) {
+	var origText = `line 1
+# BEGIN MANAGED BLOCK
+old block
+# END MANAGED BLOCK
+line 2
+EOF marker
+line 3`
+	var expected = `line 1
+line 2
+EOF marker
+line 3
+# BEGIN MANAGED BLOCK
+new block
+# END MANAGED BLOCK
+`
+	config := Config{
+		State:       true,
+		Block:       "new block",
+		InsertAfter: "EOF",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	actual := replaceTextBetweenMarkers(origText, config)
+	compare(t, expected, actual)
+
+	// Applying again is a no-op
+	compare(t, expected, replaceTextBetweenMarkers(actual, config))
+}
+
+func TestRemoveBlockAtEOFWithoutNewline(t *testing.T) {
+	var origText = `line 1
+# BEGIN MANAGED BLOCK
+old block
+# END MANAGED BLOCK`
+	config := Config{
+		State:       false,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	compare(t, "line 1\n", replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertAfterFirstMatch(t *testing.T) {
+	var origText = `server {
+    listen 80;
+}
+server {
+    listen 443;
+}
+`
+	var expected = `server {
+    # BEGIN MANAGED BLOCK
+    include managed.conf;
+    # END MANAGED BLOCK
+    listen 80;
+}
+server {
+    listen 443;
+}
+`
+	config := Config{
+		FirstMatch:  true,
+		State:       true,
+		Indent:      4,
+		Block:       "include managed.conf;",
+		InsertAfter: `^server \{`,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+
+	config.Literal = true
+	config.InsertAfter = "server {"
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
+
+func TestInsertBeforeFirstMatch(t *testing.T) {
+	var origText = `line 1
+Match User alice
+line 2
+Match User bob
+`
+	var expected = `line 1
+# BEGIN MANAGED BLOCK
+PasswordAuthentication no
+# END MANAGED BLOCK
+Match User alice
+line 2
+Match User bob
+`
+	config := Config{
+		FirstMatch:   true,
+		State:        true,
+		Block:        "PasswordAuthentication no",
+		InsertBefore: `^Match `,
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+
+	config.Literal = true
+	config.InsertBefore = "Match "
+	compare(t, expected, replaceTextBetweenMarkers(origText, config))
+}
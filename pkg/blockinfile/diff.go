@@ -0,0 +1,118 @@
+package blockinfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Number of unchanged lines shown around each change in a unified diff.
+const diffContext = 3
+
+type diffLine struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+// unifiedDiff returns the unified diff between before and after, or "" when they are equal.
+func unifiedDiff(fromFile, toFile, before, after string) string {
+	if before == after {
+		return ""
+	}
+
+	lines := diffLines(before, after)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromFile, toFile)
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk while changes are close enough to share context
+		first := nextChange(lines, start)
+		if first < 0 {
+			break
+		}
+		last := first
+		for next := nextChange(lines, last+1); next >= 0 && next-last <= 2*diffContext; next = nextChange(lines, last+1) {
+			last = next
+		}
+		hunkStart := max(first-diffContext, 0)
+		hunkEnd := min(last+diffContext+1, len(lines))
+		writeHunk(&sb, lines, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return sb.String()
+}
+
+// diffLines returns a line based edit script turning before into after
+func diffLines(before, after string) []diffLine {
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = 0
+	chars1, chars2, lineArray := dmp.DiffLinesToRunes(before, after)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMainRunes(chars1, chars2, false), lineArray)
+
+	var lines []diffLine
+	for _, d := range diffs {
+		for _, text := range strings.SplitAfter(d.Text, "\n") {
+			if text != "" {
+				lines = append(lines, diffLine{op: d.Type, text: text})
+			}
+		}
+	}
+	return lines
+}
+
+func nextChange(lines []diffLine, from int) int {
+	for i := from; i < len(lines); i++ {
+		if lines[i].op != diffmatchpatch.DiffEqual {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeHunk(sb *strings.Builder, lines []diffLine, start, end int) {
+	// Line numbers are 1-based and count the lines preceding the hunk
+	fromLine, toLine := 1, 1
+	for _, line := range lines[:start] {
+		if line.op != diffmatchpatch.DiffInsert {
+			fromLine++
+		}
+		if line.op != diffmatchpatch.DiffDelete {
+			toLine++
+		}
+	}
+
+	var fromCount, toCount int
+	var body strings.Builder
+	for _, line := range lines[start:end] {
+		switch line.op {
+		case diffmatchpatch.DiffEqual:
+			fromCount++
+			toCount++
+			body.WriteString(" ")
+		case diffmatchpatch.DiffDelete:
+			fromCount++
+			body.WriteString("-")
+		case diffmatchpatch.DiffInsert:
+			toCount++
+			body.WriteString("+")
+		}
+		body.WriteString(line.text)
+		if !strings.HasSuffix(line.text, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n%s", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount), body.String())
+}
+
+// hunkRange formats a hunk range the way diff -u does, where an empty range points at the line before it
+func hunkRange(line, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,109 @@
+package blockinfile
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiffNoChange(t *testing.T) {
+	compare(t, "", unifiedDiff("a", "b", "line 1\n", "line 1\n"))
+}
+
+func TestUnifiedDiffInsert(t *testing.T) {
+	var before = `line 1
+line 2
+line 3
+line 4
+line 5
+line 6
+line 7
+line 8
+`
+	var after = `line 1
+line 2
+line 3
+line 4
+# BEGIN MANAGED BLOCK
+new block
+# END MANAGED BLOCK
+line 5
+line 6
+line 7
+line 8
+`
+	var expected = `--- /tmp/file
++++ /tmp/file
+@@ -2,6 +2,9 @@
+ line 2
+ line 3
+ line 4
++# BEGIN MANAGED BLOCK
++new block
++# END MANAGED BLOCK
+ line 5
+ line 6
+ line 7
+`
+	compare(t, expected, unifiedDiff("/tmp/file", "/tmp/file", before, after))
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	var before = `line 1
+# BEGIN MANAGED BLOCK
+old block
+# END MANAGED BLOCK
+line 2
+line 3
+line 4
+line 5
+line 6
+line 7
+line 8
+line 9`
+	var after = `line 1
+line 2
+line 3
+line 4
+line 5
+line 6
+line 7
+line 8
+line 9
+# BEGIN MANAGED BLOCK
+old block
+# END MANAGED BLOCK
+`
+	var expected = `--- before
++++ after
+@@ -1,7 +1,4 @@
+ line 1
+-# BEGIN MANAGED BLOCK
+-old block
+-# END MANAGED BLOCK
+ line 2
+ line 3
+ line 4
+@@ -9,4 +6,7 @@
+ line 6
+ line 7
+ line 8
+-line 9
+\ No newline at end of file
++line 9
++# BEGIN MANAGED BLOCK
++old block
++# END MANAGED BLOCK
+`
+	compare(t, expected, unifiedDiff("before", "after", before, after))
+}
+
+func TestUnifiedDiffEmptyBefore(t *testing.T) {
+	var expected = `--- /dev/null
++++ /tmp/file
+@@ -0,0 +1,3 @@
++# BEGIN MANAGED BLOCK
++new block
++# END MANAGED BLOCK
+`
+	compare(t, expected, unifiedDiff("/dev/null", "/tmp/file", "",
+		"# BEGIN MANAGED BLOCK\nnew block\n# END MANAGED BLOCK\n"))
+}
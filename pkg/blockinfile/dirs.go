@@ -0,0 +1,53 @@
+package blockinfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// createParentDirs creates the missing parent directories of path, outermost first, and gives each of
+// them the DirMode, DirOwner and DirGroup of config. Existing directories are left alone. It returns
+// the directories it created, even on failure, so they can be removed again.
+func createParentDirs(path string, config Config) ([]string, error) {
+	var missing []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	dirConfig := Config{Mode: config.DirMode, Owner: config.DirOwner, Group: config.DirGroup}
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			if os.IsExist(err) {
+				// Created concurrently, so it is not ours to change
+				continue
+			}
+			return created, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+		created = append(created, missing[i])
+
+		if err := applyFileAttributes(missing[i], dirConfig); err != nil {
+			return created, fmt.Errorf("%w: %w", ErrFileAttributes, err)
+		}
+	}
+	return created, nil
+}
+
+// removeDirs removes the directories created by createParentDirs, innermost first
+func removeDirs(dirs []string) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
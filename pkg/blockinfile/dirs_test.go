@@ -0,0 +1,108 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFileCreateDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirs_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0700)
+	path := filepath.Join(dir, "myapp", "conf.d", "block.conf")
+
+	config := Config{
+		CreateDirs:  true,
+		DirMode:     "0750",
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	_, err = ApplyFile(path, config)
+	assert.NoError(t, err)
+
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	compare(t, "# BEGIN MANAGED BLOCK\ntest block\n# END MANAGED BLOCK\n", string(actual))
+
+	for _, created := range []string{filepath.Join(dir, "myapp"), filepath.Join(dir, "myapp", "conf.d")} {
+		info, err := os.Stat(created)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	}
+	// Existing directories are not touched
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
+
+func TestApplyFileWithoutCreateDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirs_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "missing", "block.conf")
+
+	config := Config{State: true, Block: "test block"}
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrUnwritableFile)
+
+	// Check mode does not create directories either
+	config.CreateDirs = true
+	config.Check = true
+	result, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.NoDirExists(t, filepath.Join(dir, "missing"))
+}
+
+func TestApplyFilesRemovesCreatedDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirs_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := transactionConfig("test block")
+	config.CreateDirs = true
+	_, err = ApplyFiles([]Target{
+		{Path: filepath.Join(dir, "a", "b", "one.conf"), Config: config},
+		{Path: filepath.Join(dir, "a", "b", "two.conf"), Config: config},
+		// Its directory is missing, so it cannot be created
+		{Path: filepath.Join(dir, "c", "three.conf"), Config: transactionConfig("test block")},
+	})
+	assert.ErrorIs(t, err, ErrUnwritableFile)
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestApplyFileRemovesCreatedDirsOnFailure(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping test that requires a user who cannot give directories to root")
+	}
+
+	dir, err := ioutil.TempDir("", "dirs_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := transactionConfig("test block")
+	config.CreateDirs = true
+	config.DirOwner = "0"
+	_, err = ApplyFile(filepath.Join(dir, "myapp", "conf.d", "block.conf"), config)
+	assert.ErrorIs(t, err, ErrFileAttributes)
+	assert.NoDirExists(t, filepath.Join(dir, "myapp"))
+}
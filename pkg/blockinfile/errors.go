@@ -0,0 +1,46 @@
+package blockinfile
+
+import "errors"
+
+// Errors returned by Apply, ApplyFile and ApplyFiles. They are wrapped with the failing
+// path and underlying cause, so use errors.Is to test for them.
+var (
+	// ErrMissingPath is returned when no target path is given.
+	ErrMissingPath = errors.New("required flag \"path\" not set")
+	// ErrConflictingInsertFlags is returned when both insertbefore and insertafter are set.
+	ErrConflictingInsertFlags = errors.New("only one of these flags can be used at a time [insertbefore|insertafter]")
+	// ErrInvalidIndent is returned when indent is negative.
+	ErrInvalidIndent = errors.New("indent must be >= 0")
+	// ErrInvalidBackupKeep is returned when the number of backups to keep is negative.
+	ErrInvalidBackupKeep = errors.New("backup-keep must be >= 0")
+	// ErrInvalidLockTimeout is returned when the lock timeout is negative.
+	ErrInvalidLockTimeout = errors.New("lock-timeout must be >= 0")
+	// ErrInvalidNewline is returned when newline is not one of lf, crlf or auto.
+	ErrInvalidNewline = errors.New("newline must be one of [lf|crlf|auto]")
+	// ErrInvalidPattern is returned when insertbefore or insertafter is not a valid regular expression.
+	ErrInvalidPattern = errors.New("invalid regular expression")
+	// ErrInvalidMode is returned when mode or dir-mode is neither an octal nor a symbolic mode.
+	ErrInvalidMode = errors.New("invalid mode")
+	// ErrInvalidOwner is returned when owner or dir-owner is not a known user.
+	ErrInvalidOwner = errors.New("invalid owner")
+	// ErrInvalidGroup is returned when group or dir-group is not a known group.
+	ErrInvalidGroup = errors.New("invalid group")
+	// ErrInvalidValidateCommand is returned when validate does not contain %s or has unbalanced quotes.
+	ErrInvalidValidateCommand = errors.New("invalid validate command")
+	// ErrMissingFile is returned when the target file does not exist and MustExist is set.
+	ErrMissingFile = errors.New("file does not exist")
+	// ErrUnreadableFile is returned when the target file cannot be read.
+	ErrUnreadableFile = errors.New("unable to read file")
+	// ErrUnwritableFile is returned when the target file cannot be created or written.
+	ErrUnwritableFile = errors.New("unable to write file")
+	// ErrLocked is returned when another process holds the lock on the target file past the lock timeout.
+	ErrLocked = errors.New("file is locked by another process")
+	// ErrBackupFailed is returned when the backup file cannot be created.
+	ErrBackupFailed = errors.New("unable to create backup")
+	// ErrFileAttributes is returned when mode, owner or group cannot be applied.
+	ErrFileAttributes = errors.New("unable to apply file attributes")
+	// ErrValidationFailed is returned when the validate command rejects the new content.
+	ErrValidationFailed = errors.New("validation failed")
+	// ErrRollbackFailed is returned when ApplyFiles cannot restore a file after a failure.
+	ErrRollbackFailed = errors.New("unable to roll back")
+)
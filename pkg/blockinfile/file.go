@@ -0,0 +1,175 @@
+package blockinfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// ApplyFile applies config to the file at path. A missing file is created unless config.MustExist is
+// set or the block is removed. In check mode the file is left untouched and the result reports what would change.
+func ApplyFile(path string, config Config) (Result, error) {
+	if path == "" {
+		return Result{}, ErrMissingPath
+	}
+	if err := checkConfig(config); err != nil {
+		return Result{}, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if !config.State {
+			// There is no block to remove
+			return Result{}, nil
+		}
+		if config.MustExist {
+			return Result{}, fmt.Errorf("%w: %s", ErrMissingFile, path)
+		}
+		if config.CreateDirs && !config.Check {
+			if created, err := createParentDirs(path, config); err != nil {
+				// Leave no directories with the wrong attributes behind for the next run
+				removeDirs(created)
+				return Result{}, err
+			}
+		}
+	}
+
+	if !config.Check {
+		// Make sure file exists by touching it
+		if err := touchFile(path, newFileMode(config)); err != nil {
+			return Result{}, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+
+		// Hold the lock from reading the file until its attributes are applied, so concurrent runs
+		// cannot overwrite each other's changes
+		lock, err := lockFile(path, config.LockTimeout)
+		if err != nil {
+			return Result{}, err
+		}
+		defer lock.unlock()
+	}
+
+	before := beforeFileAttributes(path, config)
+
+	result, err := replaceTextBetweenMarkersInFile(path, config)
+	if err != nil {
+		return result, err
+	}
+
+	if err := updateFileAttributes(path, config, before, &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// beforeFileAttributes returns the attributes of path when config needs them to report changes.
+// A file missing in check mode has no attributes to compare.
+func beforeFileAttributes(path string, config Config) *fileAttributes {
+	if config.Diff || config.Mode != "" || config.Owner != "" || config.Group != "" {
+		if attributes, err := readFileAttributes(path); err == nil {
+			return &attributes
+		}
+	}
+	return nil
+}
+
+// updateFileAttributes applies the mode, owner and group of config to path, or only plans them
+// in check mode, and records the changes since before in result. Changed attributes change the file.
+func updateFileAttributes(path string, config Config, before *fileAttributes, result *Result) error {
+	if config.Check {
+		if before != nil {
+			result.Attributes = attributeChanges(*before, plannedFileAttributes(*before, config))
+		}
+		result.Changed = result.Changed || len(result.Attributes) > 0
+		if config.Diff {
+			result.Diff = attributeDiff(result.Attributes) + result.Diff
+		}
+		return nil
+	}
+
+	// Apply ownership and permissions after file modification
+	if err := applyFileAttributes(path, config); err != nil {
+		return fmt.Errorf("%w: %w", ErrFileAttributes, err)
+	}
+
+	if before != nil {
+		after, err := readFileAttributes(path)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+		}
+		result.Attributes = attributeChanges(*before, after)
+	}
+	result.Changed = result.Changed || len(result.Attributes) > 0
+	if config.Diff {
+		result.Diff = attributeDiff(result.Attributes) + result.Diff
+	}
+	return nil
+}
+
+func replaceTextBetweenMarkersInFile(path string, config Config) (Result, error) {
+	// Read entire file content, giving us little control but
+	// making it very simple. No need to close the file.
+	// In check mode a missing file is treated as empty, since it would be created.
+	content, err := ioutil.ReadFile(path)
+	missing := config.Check && os.IsNotExist(err)
+	if err != nil && !missing {
+		return Result{}, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+
+	updatedContent := replaceTextBetweenMarkers(string(content), config)
+	result := newResult(string(content), updatedContent, config)
+	if config.Diff {
+		fromFile := path
+		if missing {
+			fromFile = os.DevNull
+		}
+		result.Diff = unifiedDiff(fromFile, path, string(content), updatedContent)
+	}
+	if result.Changed && !config.Check {
+		if config.Validate != "" {
+			if err := validateContent(path, config.Validate, updatedContent); err != nil {
+				return result, err
+			}
+		}
+		if config.Backup {
+			if result.BackupFile, err = backupFile(path, config); err != nil {
+				return result, err
+			}
+		}
+
+		if err := writeFile(path, updatedContent, config.UnsafeWrites); err != nil {
+			return result, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+
+		if config.Backup {
+			if err := pruneBackups(path, config); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// defaultFileMode is the mode of created files without a Mode, before the umask is applied
+const defaultFileMode os.FileMode = 0644
+
+// newFileMode returns the mode to create a file with, so it is never more permissive than Mode
+// while Mode is applied. The umask still applies, until Mode sets the exact bits. Mode was checked
+// by checkConfig.
+func newFileMode(config Config) os.FileMode {
+	if config.Mode != "" {
+		if mode, err := parseMode(config.Mode, defaultFileMode); err == nil {
+			return mode
+		}
+	}
+	return defaultFileMode
+}
+
+// Creates the file with mode when it does not exist. Does not update the file’s modification timestamp.
+func touchFile(path string, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return nil
+}
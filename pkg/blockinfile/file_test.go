@@ -0,0 +1,581 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getModTimeFromFile returns the modification time of an already opened file.
+func getModTimeFromFile(file *os.File) (time.Time, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func TestExistingFileAddBlock(t *testing.T) {
+	var origText = `
+line 1
+line 2
+line 3
+`
+	var expected = `
+line 1
+line 2
+line 3
+      # BEGIN MANAGED BLOCK
+      swapped with me
+      # END MANAGED BLOCK
+`
+	f, err := ioutil.TempFile("", "sample")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString(origText)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       6,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	fBeforeModTime, err := getModTimeFromFile(f)
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+	fAfterModTime, err := getModTimeFromFile(f)
+	if fBeforeModTime.UnixNano() > fAfterModTime.UnixNano() {
+		log.Fatal("Expected fAfterModTime to be after fBeforeModTime")
+	}
+
+	actual, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	compare(t, expected, string(actual))
+}
+
+func TestFileNotExistAddBlock(t *testing.T) {
+	var expected = `      # BEGIN MANAGED BLOCK
+      swapped with me
+      # END MANAGED BLOCK
+`
+	f, err := ioutil.TempFile("", "sample")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       6,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	fBeforeModTime, err := getModTimeFromFile(f)
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+	fAfterModTime, err := getModTimeFromFile(f)
+	if fBeforeModTime.UnixNano() > fAfterModTime.UnixNano() {
+		log.Fatal("Expected fAfterModTime to be after fBeforeModTime")
+	}
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	compare(t, expected, string(actual))
+}
+
+func TestNoChange(t *testing.T) {
+	var origText = `
+      # BEGIN MANAGED BLOCK
+      swapped with me
+      # END MANAGED BLOCK
+`
+	var expected = `
+      # BEGIN MANAGED BLOCK
+      swapped with me
+      # END MANAGED BLOCK
+`
+	f, err := ioutil.TempFile("", "sample")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString(origText)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       6,
+		Block:        "swapped with me",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+	}
+	fBeforeModTime, err := getModTimeFromFile(f)
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+	fAfterModTime, err := getModTimeFromFile(f)
+	if fBeforeModTime.UnixNano() != fAfterModTime.UnixNano() {
+		log.Fatal("File was not updated so expected fAfterModTime to be equal to fBeforeModTime")
+	}
+
+	actual, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer os.Remove(f.Name())
+
+	compare(t, expected, string(actual))
+}
+
+func TestFileWithModeOwnerGroup(t *testing.T) {
+	// This test requires running as root for owner/group changes
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping test that requires root privileges")
+	}
+
+	var origText = `line 1
+line 2
+`
+	var expected = `line 1
+line 2
+# BEGIN MANAGED BLOCK
+test block
+# END MANAGED BLOCK
+`
+	f, err := ioutil.TempFile("", "full_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString(origText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "test block",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+		Mode:         "0644",
+		Owner:        "root",
+		Group:        "root",
+	}
+
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check content
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, expected, string(actual))
+
+	// Check permissions
+	info, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+func TestFileWithModeOnly(t *testing.T) {
+	var origText = `line 1
+line 2
+`
+	var expected = `line 1
+line 2
+# BEGIN MANAGED BLOCK
+test block
+# END MANAGED BLOCK
+`
+	f, err := ioutil.TempFile("", "mode_only_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString(origText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		Backup:       false,
+		State:        true,
+		Indent:       0,
+		Block:        "test block",
+		InsertBefore: "",
+		InsertAfter:  "",
+		BeginMarker:  "# BEGIN MANAGED BLOCK",
+		EndMarker:    "# END MANAGED BLOCK",
+		Mode:         "0600",
+		Owner:        "",
+		Group:        "",
+	}
+
+	if _, err := ApplyFile(f.Name(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check content
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, expected, string(actual))
+
+	// Check permissions
+	info, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestApplyFileResult(t *testing.T) {
+	f, err := ioutil.TempFile("", "result_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Action: ActionInserted}, result)
+
+	result, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: false, Action: ActionUnchanged}, result)
+}
+
+func TestApplyFileMissingPath(t *testing.T) {
+	_, err := ApplyFile("", Config{State: true})
+	assert.ErrorIs(t, err, ErrMissingPath)
+}
+
+func TestApplyFileUnwritablePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unwritable_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = ApplyFile(dir, Config{State: true, Block: "test block"})
+	assert.ErrorIs(t, err, ErrUnwritableFile)
+}
+
+func TestApplyFileInvalidAttributes(t *testing.T) {
+	f, err := ioutil.TempFile("", "invalid_attributes_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	// Invalid attributes fail before the file is written, and in check mode
+	for _, check := range []bool{false, true} {
+		_, err = ApplyFile(f.Name(), Config{State: true, Block: "test block", Check: check, Mode: "u+q"})
+		assert.ErrorIs(t, err, ErrInvalidMode)
+		_, err = ApplyFile(f.Name(), Config{State: true, Block: "test block", Check: check, DirMode: "bogus"})
+		assert.ErrorIs(t, err, ErrInvalidMode)
+		_, err = ApplyFile(f.Name(), Config{State: true, Block: "test block", Check: check, Owner: "no-such-user-blockinfile"})
+		assert.ErrorIs(t, err, ErrInvalidOwner)
+		_, err = ApplyFile(f.Name(), Config{State: true, Block: "test block", Check: check, DirGroup: "no-such-group-blockinfile"})
+		assert.ErrorIs(t, err, ErrInvalidGroup)
+	}
+
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Empty(t, string(actual))
+}
+
+func TestCheckModeDoesNotModifyFile(t *testing.T) {
+	var origText = `line 1
+line 2
+`
+	f, err := ioutil.TempFile("", "check_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString(origText)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+	os.Chmod(f.Name(), 0644)
+
+	config := Config{
+		Backup:      true,
+		Check:       true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Mode:        "0600",
+	}
+
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.Equal(t, ActionInserted, result.Action)
+	assert.Empty(t, result.BackupFile)
+	assert.Equal(t, []AttributeChange{{Name: "mode", Before: "0644", After: "0600"}}, result.Attributes)
+
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, origText, string(actual))
+
+	info, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	backups, err := filepath.Glob(f.Name() + ".*")
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestApplyFileCreateFalse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "create_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "missing.txt")
+
+	config := Config{
+		MustExist:   true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrMissingFile)
+	assert.NoFileExists(t, path)
+
+	config.Check = true
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestApplyFileRemoveFromMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "create_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "missing.txt")
+
+	config := Config{
+		State:       false,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+	result, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+	assert.NoFileExists(t, path)
+}
+
+func TestCheckModeDoesNotCreateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "missing.txt")
+
+	config := Config{
+		Check:       true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	result, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Action: ActionInserted}, result)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApplyFileDiff(t *testing.T) {
+	f, err := ioutil.TempFile("", "diff_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString("line 1\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+	os.Chmod(f.Name(), 0644)
+
+	config := Config{
+		Diff:        true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Mode:        "0600",
+	}
+	var expected = `old mode 0644
+new mode 0600
+--- ` + f.Name() + `
++++ ` + f.Name() + `
+@@ -1 +1,4 @@
+ line 1
++# BEGIN MANAGED BLOCK
++test block
++# END MANAGED BLOCK
+`
+
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	compare(t, expected, result.Diff)
+
+	// Check mode reports the same diff without applying it
+	os.WriteFile(f.Name(), []byte("line 1\n"), 0644)
+	os.Chmod(f.Name(), 0644)
+	config.Check = true
+	result, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	compare(t, expected, result.Diff)
+
+	result, err = ApplyFile(f.Name()+".missing", config)
+	assert.NoError(t, err)
+	assert.Contains(t, result.Diff, "--- "+os.DevNull+"\n")
+}
+
+func TestApplyFileReportsBackupAndAttributes(t *testing.T) {
+	f, err := ioutil.TempFile("", "report_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+	os.Chmod(f.Name(), 0644)
+
+	config := Config{
+		Backup:      true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Mode:        "0600",
+	}
+
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.FileExists(t, result.BackupFile)
+	defer os.Remove(result.BackupFile)
+	assert.Equal(t, []AttributeChange{{Name: "mode", Before: "0644", After: "0600"}}, result.Attributes)
+
+	result, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Empty(t, result.BackupFile)
+	assert.Empty(t, result.Attributes)
+}
+
+func TestApplyFileAttributesOnlyChange(t *testing.T) {
+	f, err := ioutil.TempFile("", "attributes_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = f.WriteString("# BEGIN MANAGED BLOCK\ntest block\n# END MANAGED BLOCK\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+	os.Chmod(f.Name(), 0644)
+
+	config := Config{
+		Check:       true,
+		State:       true,
+		Block:       "test block",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Mode:        "g+w,o=",
+	}
+	want := Result{
+		Changed:    true,
+		Action:     ActionUnchanged,
+		Attributes: []AttributeChange{{Name: "mode", Before: "0644", After: "0660"}},
+	}
+
+	// Check mode predicts symbolic modes
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	config.Check = false
+	result, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	result, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+}
+
+func TestNewFileMode(t *testing.T) {
+	assert.Equal(t, defaultFileMode, newFileMode(Config{}))
+	assert.Equal(t, os.FileMode(0600), newFileMode(Config{Mode: "0600"}))
+	assert.Equal(t, os.FileMode(0640), newFileMode(Config{Mode: "o="}))
+	assert.Equal(t, defaultFileMode, newFileMode(Config{Mode: "not-a-mode"}))
+}
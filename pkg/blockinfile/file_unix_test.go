@@ -0,0 +1,40 @@
+//go:build !windows
+
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFileNewFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mode_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	umask := syscall.Umask(027)
+	defer syscall.Umask(umask)
+
+	config := Config{State: true, Block: "test block"}
+	_, err = ApplyFile(filepath.Join(dir, "default.txt"), config)
+	assert.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, "default.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm(), "default mode honors the umask")
+
+	// An explicit mode is exact, regardless of the umask
+	config.Mode = "0664"
+	_, err = ApplyFile(filepath.Join(dir, "explicit.txt"), config)
+	assert.NoError(t, err)
+	info, err = os.Stat(filepath.Join(dir, "explicit.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0664), info.Mode().Perm())
+}
@@ -0,0 +1,139 @@
+package blockinfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockPollInterval is how often a lock held by another process is tried again
+const lockPollInterval = 50 * time.Millisecond
+
+// lockFileSuffix ends the name of the lock file of a file, .<name>.blockinfile.lock
+const lockFileSuffix = ".blockinfile.lock"
+
+// lockFileMode lets other users open the lock file to lock it, without writing to it
+const lockFileMode os.FileMode = 0644
+
+// fileLock holds exclusive advisory locks on the lock file of a file and on the file itself until
+// unlock. The lock file is nil when the directory does not allow creating it, and the file is nil
+// when it does not exist yet.
+type fileLock struct {
+	path   string
+	file   *os.File
+	target *os.File
+}
+
+// lockPath returns the lock file of path, next to the file a symlink points to so every symlink to
+// a file shares its lock. Files are replaced by renaming a new file over them, so locking the file
+// itself would lock a file that is gone after the rename, while the lock file is never renamed.
+func lockPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+lockFileSuffix)
+}
+
+// IsLockFile reports whether path is named like the lock file kept next to a file while it is
+// updated, so callers listing files can skip the lock files of concurrent runs.
+func IsLockFile(path string) bool {
+	name := filepath.Base(path)
+	return len(name) > len(lockFileSuffix)+1 && strings.HasPrefix(name, ".") && strings.HasSuffix(name, lockFileSuffix)
+}
+
+// lockFile locks path until unlock, waiting up to timeout for other processes to release their
+// locks, or indefinitely when timeout is 0. The lock file is locked first, then the file itself when
+// it exists. Writing a file renames a new file over it, which the lock file survives, so the lock
+// file is what concurrent runs wait for. The file itself is locked for the runs that cannot create
+// the lock file, e.g. with unsafe writes in a directory they cannot write to, since they write the
+// file in place.
+func lockFile(path string, timeout time.Duration) (*fileLock, error) {
+	if !lockSupported {
+		return &fileLock{}, nil
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		target = resolved
+	}
+
+	lock := &fileLock{path: lockPath(path)}
+	var lockErr, err error
+	lock.file, lockErr = lockExisting(lock.path, true, deadline)
+	if errors.Is(lockErr, ErrLocked) {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+	}
+	lock.target, err = lockExisting(target, false, deadline)
+	switch {
+	case errors.Is(err, ErrLocked):
+		lock.unlock()
+		return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+	case lockErr != nil && err != nil:
+		// Neither the lock file nor the file can be locked
+		return nil, fmt.Errorf("%w: %w", ErrUnwritableFile, lockErr)
+	}
+	return lock, nil
+}
+
+// lockExisting opens the file at path, creating it when create is set, and locks it before deadline.
+// Since unlock removes the lock file and writes replace the file, a lock taken on a file removed or
+// replaced meanwhile is taken again on the current one.
+func lockExisting(path string, create bool, deadline time.Time) (*os.File, error) {
+	flag := os.O_RDONLY
+	if create {
+		flag |= os.O_CREATE
+	}
+	for {
+		file, err := os.OpenFile(path, flag, lockFileMode)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			if isCurrent(path, file) {
+				return file, nil
+			}
+			file.Close()
+			continue
+		}
+		file.Close()
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrLocked
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// isCurrent reports whether file still is the file at path
+func isCurrent(path string, file *os.File) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && os.SameFile(current, info)
+}
+
+// unlock releases the locks and removes the lock file, so none is left behind
+func (l *fileLock) unlock() error {
+	if l.target != nil {
+		l.target.Close()
+	}
+	if l.file == nil {
+		return nil
+	}
+	os.Remove(l.path)
+	return l.file.Close()
+}
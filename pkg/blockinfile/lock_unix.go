@@ -0,0 +1,21 @@
+//go:build !windows
+
+package blockinfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockSupported reports whether lockFile locks files on this platform
+const lockSupported = true
+
+// tryLock takes an exclusive flock on file without waiting. It returns false when another
+// process holds a lock on the file.
+func tryLock(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,180 @@
+//go:build !windows
+
+package blockinfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// holdLock locks the file at path like another process would and returns the file to close to release it
+func holdLock(path string) *os.File {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, lockFileMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+func TestApplyFileLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	held := holdLock(lockPath(path))
+	config := transactionConfig("10.0.0.1 one")
+	config.LockTimeout = 100 * time.Millisecond
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrLocked)
+	_, err = ApplyFiles([]Target{{Path: path, Config: config}})
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// A lock on the file itself, from a run that cannot create the lock file, is waited for as well
+	heldFile := holdLock(path)
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrLocked)
+	heldFile.Close()
+
+	// Check mode only reads the file
+	config.Check = true
+	_, err = ApplyFile(path, config)
+	assert.NoError(t, err)
+
+	// Without a timeout, wait until the lock is released
+	config = transactionConfig("10.0.0.1 one")
+	time.AfterFunc(100*time.Millisecond, func() { held.Close() })
+	result, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+}
+
+func TestLockFileSurvivesReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// Replacing the file keeps it locked
+	lock, err := lockFile(path, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, writeFileAtomic(path, "new\n", path))
+	_, err = lockFile(path, 100*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	assert.NoError(t, lock.unlock())
+	lock, err = lockFile(path, 100*time.Millisecond)
+	assert.NoError(t, err)
+	lock.unlock()
+}
+
+func TestApplyFileLocksFileInReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping test that requires a directory root cannot write to")
+	}
+
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// The lock file cannot be created, so unsafe writes lock the file itself
+	os.Chmod(dir, 0555)
+	defer os.Chmod(dir, 0755)
+	config := transactionConfig("10.0.0.1 one")
+	config.UnsafeWrites = true
+	config.LockTimeout = 100 * time.Millisecond
+
+	held := holdLock(path)
+	_, err = ApplyFile(path, config)
+	assert.ErrorIs(t, err, ErrLocked)
+	held.Close()
+
+	result, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	compare(t, "127.0.0.1 localhost\n# BEGIN MANAGED BLOCK\n10.0.0.1 one\n# END MANAGED BLOCK\n", string(actual))
+}
+
+func TestApplyFilesHoldsLockUntilRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hosts := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// Many files to commit after hosts keep the transaction busy, until the last one fails
+	targets := []Target{{Path: hosts, Config: transactionConfig("10.0.0.1 one")}}
+	for i := 0; i < 50; i++ {
+		targets = append(targets, Target{Path: filepath.Join(dir, fmt.Sprintf("file%02d", i)), Config: transactionConfig("block")})
+	}
+	// Its directory is missing, so it cannot be created
+	targets = append(targets, Target{Path: filepath.Join(dir, "missing", "failing"), Config: transactionConfig("block")})
+
+	// Another process updates hosts as soon as the transaction replaced it
+	other := transactionConfig("10.0.0.2 two")
+	other.BeginMarker = "# BEGIN OTHER"
+	other.EndMarker = "# END OTHER"
+	done := make(chan bool)
+	observed := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				observed <- false
+				return
+			default:
+			}
+			if content, err := ioutil.ReadFile(hosts); err == nil && string(content) != "127.0.0.1 localhost\n" {
+				_, err := ApplyFile(hosts, other)
+				assert.NoError(t, err)
+				observed <- true
+				return
+			}
+		}
+	}()
+
+	_, err = ApplyFiles(targets)
+	close(done)
+	assert.ErrorIs(t, err, ErrUnwritableFile)
+	if !<-observed {
+		t.Fatal("the transaction finished before hosts was seen replaced")
+	}
+
+	// The other update waited for the rollback, instead of being overwritten by it
+	actual, err := ioutil.ReadFile(hosts)
+	assert.NoError(t, err)
+	compare(t, "127.0.0.1 localhost\n# BEGIN OTHER\n10.0.0.2 two\n# END OTHER\n", string(actual))
+}
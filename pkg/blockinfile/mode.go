@@ -0,0 +1,142 @@
+package blockinfile
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// permissionBits are the bits of an os.FileMode set by chmod
+const permissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Unix mode bits that os.FileMode keeps as separate flags
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// fileModeFromUnix converts unix mode bits, e.g. 04755, to an os.FileMode
+func fileModeFromUnix(bits uint32) os.FileMode {
+	mode := os.FileMode(bits) & os.ModePerm
+	if bits&unixSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&unixSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&unixSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// unixMode converts the permission bits of an os.FileMode to unix mode bits
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode & os.ModePerm)
+	if mode&os.ModeSetuid != 0 {
+		bits |= unixSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= unixSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= unixSticky
+	}
+	return bits
+}
+
+// parseMode returns the permission bits described by mode, either octal such as "0644" or
+// symbolic such as "u+rwx,g-w,o=r", where symbolic modes are applied to current
+func parseMode(mode string, current os.FileMode) (os.FileMode, error) {
+	if bits, err := strconv.ParseUint(mode, 8, 32); err == nil {
+		if bits > 07777 {
+			return 0, fmt.Errorf("%w %q", ErrInvalidMode, mode)
+		}
+		return fileModeFromUnix(uint32(bits)), nil
+	}
+
+	bits, err := parseSymbolicMode(mode, unixMode(current), current.IsDir())
+	if err != nil {
+		return 0, err
+	}
+	return fileModeFromUnix(bits), nil
+}
+
+// parseSymbolicMode applies a symbolic mode the way chmod does, except that a clause without
+// users applies to all of them regardless of the umask. Clauses are separated by commas, each made
+// of users [ugoa], followed by one or more operators [-+=] with permissions [rwxXst] or [ugo].
+func parseSymbolicMode(mode string, current uint32, isDir bool) (uint32, error) {
+	invalid := fmt.Errorf("%w %q", ErrInvalidMode, mode)
+	for _, clause := range strings.Split(mode, ",") {
+		// The bits each user may change, including setuid, setgid and sticky
+		var users uint32
+		i := 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				users |= unixSetuid | 0700
+			case 'g':
+				users |= unixSetgid | 0070
+			case 'o':
+				users |= unixSticky | 0007
+			case 'a':
+				users |= 07777
+			}
+		}
+		if users == 0 {
+			users = 07777
+		}
+		if i == len(clause) {
+			return 0, invalid
+		}
+
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, invalid
+			}
+			i++
+
+			var perms uint32
+			if i < len(clause) && strings.IndexByte("ugo", clause[i]) >= 0 {
+				// Copy the permissions of another user to every user
+				shift := map[byte]uint{'u': 6, 'g': 3, 'o': 0}[clause[i]]
+				rwx := current >> shift & 07
+				perms = rwx<<6 | rwx<<3 | rwx
+				i++
+			} else {
+				for ; i < len(clause) && strings.IndexByte("rwxXst", clause[i]) >= 0; i++ {
+					switch clause[i] {
+					case 'r':
+						perms |= 0444
+					case 'w':
+						perms |= 0222
+					case 'x':
+						perms |= 0111
+					case 'X':
+						// Execute only for directories or files executable by someone already
+						if isDir || current&0111 != 0 {
+							perms |= 0111
+						}
+					case 's':
+						perms |= unixSetuid | unixSetgid
+					case 't':
+						perms |= unixSticky
+					}
+				}
+			}
+
+			switch op {
+			case '+':
+				current |= perms & users
+			case '-':
+				current &^= perms & users
+			case '=':
+				current = current&^users | perms&users
+			}
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,51 @@
+package blockinfile
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		current os.FileMode
+		want    os.FileMode
+	}{
+		{"0644", 0755, 0644},
+		{"600", 0755, 0600},
+		{"0", 0755, 0},
+		{"2755", 0644, os.ModeSetgid | 0755},
+		{"u+x", 0644, 0744},
+		{"u+rwx,g-w,o=r", 0666, 0744},
+		{"a-w", 0666, 0444},
+		{"+x", 0644, 0755},
+		{"go=", 0777, 0700},
+		{"g=u", 0640, 0660},
+		{"o+X", 0644, 0644},
+		{"o+X", 0744, 0745},
+		{"o+X", os.ModeDir | 0750, 0751},
+		{"u+s,g+s", 0755, os.ModeSetuid | os.ModeSetgid | 0755},
+		{"+t", 0777, os.ModeSticky | 0777},
+		{"u-x+w", 0544, 0644},
+		{"u=rw,u-s", os.ModeSetuid | 0755, 0655},
+	}
+	for _, test := range tests {
+		got, err := parseMode(test.mode, test.current)
+		assert.NoError(t, err, test.mode)
+		assert.Equal(t, test.want, got, "%s applied to %v", test.mode, test.current)
+	}
+}
+
+func TestParseModeInvalid(t *testing.T) {
+	for _, mode := range []string{"", "u", "u+rwz", "10000", "u+x,", "q+x", "not-a-mode"} {
+		_, err := parseMode(mode, 0644)
+		assert.Error(t, err, mode)
+	}
+}
+
+func TestUnixMode(t *testing.T) {
+	assert.Equal(t, uint32(07755), unixMode(os.ModeSetuid|os.ModeSetgid|os.ModeSticky|0755))
+	assert.Equal(t, os.ModeSetuid|os.ModeSetgid|os.ModeSticky|0755, fileModeFromUnix(07755))
+}
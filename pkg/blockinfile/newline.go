@@ -0,0 +1,39 @@
+package blockinfile
+
+import "strings"
+
+// Values of Newline
+const (
+	newlineAuto = "auto"
+	newlineLF   = "lf"
+	newlineCRLF = "crlf"
+)
+
+// newline returns the line break to write the markers and the block with: the one set by Newline,
+// or the most common line break of content
+func newline(content string, config Config) string {
+	switch config.Newline {
+	case newlineLF:
+		return "\n"
+	case newlineCRLF:
+		return "\r\n"
+	default:
+		return detectNewline(content)
+	}
+}
+
+// detectNewline returns the most common line break of content, either \n, \r\n or \r. Content without
+// line breaks, or as many of several kinds, gets \n, then \r\n.
+func detectNewline(content string) string {
+	crlf := strings.Count(content, "\r\n")
+	lf := strings.Count(content, "\n") - crlf
+	cr := strings.Count(content, "\r") - crlf
+	switch {
+	case crlf > lf && crlf >= cr:
+		return "\r\n"
+	case cr > lf && cr > crlf:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
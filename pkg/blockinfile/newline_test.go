@@ -0,0 +1,89 @@
+package blockinfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectNewline(t *testing.T) {
+	assert.Equal(t, "\n", detectNewline(""))
+	assert.Equal(t, "\n", detectNewline("line 1"))
+	assert.Equal(t, "\n", detectNewline("line 1\nline 2\n"))
+	assert.Equal(t, "\r\n", detectNewline("line 1\r\nline 2\r\n"))
+	assert.Equal(t, "\r", detectNewline("line 1\rline 2\r"))
+	assert.Equal(t, "\r\n", detectNewline("line 1\r\nline 2\r\nline 3\n"))
+	assert.Equal(t, "\n", detectNewline("line 1\r\nline 2\n"))
+}
+
+func TestReplaceTextBetweenMarkersCRLF(t *testing.T) {
+	config := Config{
+		State:       true,
+		Block:       "new block 1\nnew block 2",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	inserted := replaceTextBetweenMarkers("line 1\r\nline 2\r\n", config)
+	compare(t, "line 1\r\nline 2\r\n# BEGIN MANAGED BLOCK\r\nnew block 1\r\nnew block 2\r\n# END MANAGED BLOCK\r\n", inserted)
+
+	// The existing block is found and replaced, not inserted again
+	config.Block = "new block 3"
+	compare(t, "line 1\r\nline 2\r\n# BEGIN MANAGED BLOCK\r\nnew block 3\r\n# END MANAGED BLOCK\r\n",
+		replaceTextBetweenMarkers(inserted, config))
+	assert.Equal(t, ActionReplaced, newResult(inserted, replaceTextBetweenMarkers(inserted, config), config).Action)
+
+	config.State = false
+	compare(t, "line 1\r\nline 2\r\n", replaceTextBetweenMarkers(inserted, config))
+}
+
+func TestReplaceTextBetweenMarkersCR(t *testing.T) {
+	config := Config{
+		State:       true,
+		Block:       "new block",
+		InsertAfter: "^line 1$",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	inserted := replaceTextBetweenMarkers("line 1\rline 2\r", config)
+	compare(t, "line 1\r# BEGIN MANAGED BLOCK\rnew block\r# END MANAGED BLOCK\rline 2\r", inserted)
+	compare(t, inserted, replaceTextBetweenMarkers(inserted, config))
+}
+
+func TestReplaceTextBetweenMarkersInsertAfterCRLF(t *testing.T) {
+	config := Config{
+		State:       true,
+		Block:       "new block",
+		InsertAfter: "^line 2$",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	compare(t, "line 1\r\nline 2\r\n# BEGIN MANAGED BLOCK\r\nnew block\r\n# END MANAGED BLOCK\r\nline 3\r\n",
+		replaceTextBetweenMarkers("line 1\r\nline 2\r\nline 3\r\n", config))
+	compare(t, "line 1\r\nline 2\r\n# BEGIN MANAGED BLOCK\r\nnew block\r\n# END MANAGED BLOCK\r\n",
+		replaceTextBetweenMarkers("line 1\r\nline 2", config))
+}
+
+func TestReplaceTextBetweenMarkersNewlineOverride(t *testing.T) {
+	config := Config{
+		State:       true,
+		Block:       "new block",
+		Newline:     "crlf",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+
+	// The block written with \n is replaced by one with \r\n
+	compare(t, "line 1\n# BEGIN MANAGED BLOCK\r\nnew block\r\n# END MANAGED BLOCK\r\n",
+		replaceTextBetweenMarkers("line 1\n# BEGIN MANAGED BLOCK\nold block\n# END MANAGED BLOCK\n", config))
+
+	config.Newline = "lf"
+	compare(t, "line 1\r\n# BEGIN MANAGED BLOCK\nnew block\n# END MANAGED BLOCK\n",
+		replaceTextBetweenMarkers("line 1\r\n", config))
+
+	config.Newline = "cr"
+	_, _, err := Apply("line 1\n", config)
+	assert.ErrorIs(t, err, ErrInvalidNewline)
+}
@@ -0,0 +1,126 @@
+package blockinfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup is a backup of a file made by ApplyFile or ApplyFiles.
+type Backup struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+// ListBackups returns the backups of path, newest first. backupDir is the BackupDir the backups
+// were written to, or "" for backups next to the file.
+func ListBackups(path, backupDir string) ([]Backup, error) {
+	pattern, err := backupPath(path, backupDir, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+	dir, prefix := filepath.Dir(pattern), filepath.Base(path)+"."
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		// Only names ending in a timestamp are backups, e.g. hosts.allow is not a backup of hosts
+		if t, ok := parseBackupTime(strings.TrimPrefix(entry.Name(), prefix)); ok {
+			backups = append(backups, Backup{Path: filepath.Join(dir, entry.Name()), Time: t})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// Restore replaces path with the backup file atomically, giving it the content, mode, owner, group and
+// extended attributes of the backup. Check, Diff, UnsafeWrites and LockTimeout of config apply as for
+// ApplyFile.
+func Restore(path, backup string, config Config) (Result, error) {
+	if path == "" {
+		return Result{}, ErrMissingPath
+	}
+
+	content, err := ioutil.ReadFile(backup)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+	backupAttributes, err := readFileAttributes(backup)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+
+	if !config.Check {
+		// Hold the lock from reading the file until it is restored, like ApplyFile
+		lock, err := lockFile(path, config.LockTimeout)
+		if err != nil {
+			return Result{}, err
+		}
+		defer lock.unlock()
+	}
+
+	// A missing file is restored as well
+	current, err := ioutil.ReadFile(path)
+	missing := os.IsNotExist(err)
+	if err != nil && !missing {
+		return Result{}, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+
+	result := Result{Changed: missing || string(current) != string(content)}
+	if !missing {
+		before, err := readFileAttributes(path)
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+		}
+		result.Attributes = attributeChanges(before, backupAttributes)
+		result.Changed = result.Changed || len(result.Attributes) > 0
+	}
+	if result.Changed {
+		result.Action = ActionRestored
+	}
+	if config.Diff {
+		fromFile := path
+		if missing {
+			fromFile = os.DevNull
+		}
+		result.Diff = attributeDiff(result.Attributes) + unifiedDiff(fromFile, path, string(current), string(content))
+	}
+	if !result.Changed || config.Check {
+		return result, nil
+	}
+
+	// Restore the file a symlink points to, like writeFile
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		target = resolved
+	}
+	if err := writeFileAtomic(target, string(content), backup); err != nil {
+		if !config.UnsafeWrites || missing {
+			return result, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+		if err := writeFileInPlace(target, string(content)); err != nil {
+			return result, fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+		}
+		info, err := os.Stat(backup)
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+		}
+		if err := copyFileAttributes(info, backup, target); err != nil {
+			return result, fmt.Errorf("%w: %w", ErrFileAttributes, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+
+	for _, name := range []string{
+		"hosts",
+		"hosts.allow",
+		"hosts.20240102T030405.000000000Z",
+		"hosts.2023-01-02T03:04:05Z",
+		"hosts.20250102T030405.000000000Z",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	backups, err := ListBackups(path, "")
+	assert.NoError(t, err)
+	var names []string
+	for _, backup := range backups {
+		names = append(names, filepath.Base(backup.Path))
+	}
+	assert.Equal(t, []string{"hosts.20250102T030405.000000000Z", "hosts.20240102T030405.000000000Z", "hosts.2023-01-02T03:04:05Z"}, names)
+
+	// A backup dir that does not exist yet has no backups
+	backups, err = ListBackups(path, filepath.Join(dir, "backups"))
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0640); err != nil {
+		log.Fatal(err)
+	}
+	os.Chmod(path, 0640)
+
+	config := Config{
+		Backup:      true,
+		BackupDir:   filepath.Join(dir, "backups"),
+		State:       true,
+		Block:       "10.0.0.1 one",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Mode:        "0644",
+	}
+	applied, err := ApplyFile(path, config)
+	assert.NoError(t, err)
+
+	backups, err := ListBackups(path, config.BackupDir)
+	assert.NoError(t, err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, applied.BackupFile, backups[0].Path)
+
+	// Check mode shows the diff without restoring
+	result, err := Restore(path, backups[0].Path, Config{Check: true, Diff: true})
+	assert.NoError(t, err)
+	assert.Equal(t, ActionRestored, result.Action)
+	compare(t, `old mode 0644
+new mode 0640
+--- `+path+`
++++ `+path+`
+@@ -1,4 +1 @@
+ 127.0.0.1 localhost
+-# BEGIN MANAGED BLOCK
+-10.0.0.1 one
+-# END MANAGED BLOCK
+`, result.Diff)
+
+	result, err = Restore(path, backups[0].Path, Config{})
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	compare(t, "127.0.0.1 localhost\n", string(actual))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	result, err = Restore(path, backups[0].Path, Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+
+	// A deleted file is restored too
+	os.Remove(path)
+	result, err = Restore(path, backups[0].Path, Config{})
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+	assert.FileExists(t, path)
+}
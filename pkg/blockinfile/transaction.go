@@ -0,0 +1,289 @@
+package blockinfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Target is a file and the config to apply to it with ApplyFiles.
+type Target struct {
+	Path   string
+	Config Config
+}
+
+// TransactionError reports the target that made ApplyFiles fail.
+type TransactionError struct {
+	// Index of the failing target
+	Index int
+	Err   error
+}
+
+func (e *TransactionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
+
+// stagedFile is the original and the new content of a file changed by a transaction
+type stagedFile struct {
+	path              string
+	original, content string
+	exists            bool
+	// Merged from the targets outside of check mode
+	created, unsafeWrites bool
+	// The config of the target creating the file, for its parent directories
+	createConfig Config
+	// The first target with a backup and its config
+	backupIndex  int
+	backupConfig Config
+
+	// Set once the file is committed, to roll it back
+	info        os.FileInfo
+	backupFile  string
+	createdDirs []string
+}
+
+// ApplyFiles applies every target as a single transaction: either all of them are applied or none.
+// The new content of every file is computed and validated before anything is written, so an invalid
+// config, an unreadable file or a failing validate command fails without changes. When writing a
+// file or applying its mode, owner or group fails, the files already written get their original
+// content and attributes back, and files and directories the transaction created are removed.
+// Targets sharing a path are applied to that file in order. Files are locked, like ApplyFile does,
+// until the transaction is over, rollback included. Targets in check mode only report what would
+// change. Errors are returned as a *TransactionError. When old backups cannot be pruned after every
+// file was committed, nothing is rolled back and the results are returned with the error.
+func ApplyFiles(targets []Target) ([]Result, error) {
+	results := make([]Result, len(targets))
+	files := make(map[string]*stagedFile)
+	var order []*stagedFile
+
+	for i, target := range targets {
+		if target.Path == "" {
+			return nil, &TransactionError{Index: i, Err: ErrMissingPath}
+		}
+		if err := checkConfig(target.Config); err != nil {
+			return nil, &TransactionError{Index: i, Err: err}
+		}
+	}
+
+	locks, err := lockFiles(targets)
+	defer func() {
+		for _, lock := range locks {
+			lock.unlock()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// Stage the new content of every file
+	for i, target := range targets {
+		file, ok := files[target.Path]
+		if !ok {
+			content, err := ioutil.ReadFile(target.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, &TransactionError{Index: i, Err: fmt.Errorf("%w: %w", ErrUnreadableFile, err)}
+			}
+			file = &stagedFile{path: target.Path, original: string(content), content: string(content), exists: err == nil}
+			files[target.Path] = file
+			order = append(order, file)
+		}
+		if !file.exists && !file.created {
+			if !target.Config.State {
+				// There is no block to remove
+				continue
+			}
+			if target.Config.MustExist {
+				return nil, &TransactionError{Index: i, Err: fmt.Errorf("%w: %s", ErrMissingFile, target.Path)}
+			}
+		}
+
+		updatedContent := replaceTextBetweenMarkers(file.content, target.Config)
+		results[i] = newResult(file.content, updatedContent, target.Config)
+		if target.Config.Diff {
+			fromFile := target.Path
+			if target.Config.Check && !file.exists {
+				fromFile = os.DevNull
+			}
+			results[i].Diff = unifiedDiff(fromFile, target.Path, file.content, updatedContent)
+		}
+		if !target.Config.Check {
+			if !file.exists && !file.created {
+				file.created = true
+				file.createConfig = target.Config
+			}
+			file.content = updatedContent
+			if target.Config.Backup && !file.backupConfig.Backup {
+				file.backupIndex, file.backupConfig = i, target.Config
+			}
+			file.unsafeWrites = file.unsafeWrites || target.Config.UnsafeWrites
+		}
+	}
+
+	// Validate the final content of every file before writing any of them
+	for i, target := range targets {
+		file := files[target.Path]
+		if target.Config.Validate != "" && !target.Config.Check && file.content != file.original {
+			if err := validateContent(target.Path, target.Config.Validate, file.content); err != nil {
+				return nil, &TransactionError{Index: i, Err: err}
+			}
+		}
+	}
+
+	// Commit every file, followed by the attributes of its targets
+	for i, target := range targets {
+		file := files[target.Path]
+		if !file.exists && !file.created {
+			continue
+		}
+		if !target.Config.Check && file.info == nil {
+			if err := file.write(); err != nil {
+				return nil, rollback(order, &TransactionError{Index: i, Err: err})
+			}
+		}
+		if target.Config.Backup && !target.Config.Check {
+			results[i].BackupFile = file.backupFile
+		}
+
+		before := beforeFileAttributes(target.Path, target.Config)
+		if err := updateFileAttributes(target.Path, target.Config, before, &results[i]); err != nil {
+			return nil, rollback(order, &TransactionError{Index: i, Err: err})
+		}
+	}
+
+	// Old backups are only pruned once nothing can be rolled back
+	for _, file := range order {
+		if file.backupFile != "" {
+			if err := pruneBackups(file.path, file.backupConfig); err != nil {
+				return results, &TransactionError{Index: file.backupIndex, Err: err}
+			}
+		}
+	}
+	return results, nil
+}
+
+// lockFiles locks the files written by targets until the transaction is over, in the order of their
+// lock files so concurrent transactions cannot deadlock. Missing files are locked as well when they
+// are created, unless their directory is missing too. Each file waits for the LockTimeout of the
+// first target writing it.
+func lockFiles(targets []Target) ([]*fileLock, error) {
+	first := make(map[string]int)
+	var paths []string
+	for i, target := range targets {
+		if target.Config.Check {
+			continue
+		}
+		if _, err := os.Stat(target.Path); err != nil {
+			created := target.Config.State && !target.Config.MustExist
+			if _, err := os.Stat(filepath.Dir(target.Path)); err != nil || !created {
+				continue
+			}
+		}
+		// Symlinks to the same file share its lock, which must only be taken once
+		path := lockPath(target.Path)
+		if _, ok := first[path]; !ok {
+			first[path] = i
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var locks []*fileLock
+	for _, path := range paths {
+		target := targets[first[path]]
+		lock, err := lockFile(target.Path, target.Config.LockTimeout)
+		if err != nil {
+			return locks, &TransactionError{Index: first[path], Err: err}
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// write creates the file if needed, backs it up and replaces its content, keeping what rollback needs
+func (f *stagedFile) write() error {
+	if f.created && f.createConfig.CreateDirs {
+		var err error
+		if f.createdDirs, err = createParentDirs(f.path, f.createConfig); err != nil {
+			return err
+		}
+	}
+	if err := touchFile(f.path, newFileMode(f.createConfig)); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreadableFile, err)
+	}
+	f.info = info
+
+	if f.content == f.original {
+		return nil
+	}
+	if f.backupConfig.Backup {
+		if f.backupFile, err = backupFile(f.path, f.backupConfig); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(f.path, f.content, f.unsafeWrites); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnwritableFile, err)
+	}
+	return nil
+}
+
+// rollback restores the committed files in reverse order and returns err, joined with any
+// failure to restore a file
+func rollback(files []*stagedFile, err *TransactionError) error {
+	var errs []error
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].info == nil && len(files[i].createdDirs) == 0 {
+			continue
+		}
+		if rollbackErr := files[i].restore(); rollbackErr != nil {
+			errs = append(errs, fmt.Errorf("%w %s: %w", ErrRollbackFailed, files[i].path, rollbackErr))
+		}
+	}
+	if len(errs) > 0 {
+		err.Err = errors.Join(append([]error{err.Err}, errs...)...)
+	}
+	return err
+}
+
+// restore gives the file its original content and attributes back, or removes it and its parent
+// directories if the transaction created them
+func (f *stagedFile) restore() error {
+	if !f.exists {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := removeDirs(f.createdDirs); err != nil {
+			return err
+		}
+	} else {
+		// Restore the file a symlink points to, like writeFile
+		target, err := filepath.EvalSymlinks(f.path)
+		if err != nil {
+			return err
+		}
+		if content, err := ioutil.ReadFile(target); err != nil || string(content) != f.original {
+			if err := writeFile(target, f.original, f.unsafeWrites); err != nil {
+				return err
+			}
+		}
+		if err := setFileAttributes(target, f.info); err != nil {
+			return err
+		}
+	}
+
+	// The backup is a copy of the restored file
+	if f.backupFile != "" {
+		return os.Remove(f.backupFile)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package blockinfile
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func transactionConfig(block string) Config {
+	return Config{
+		State:       true,
+		Block:       block,
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+	}
+}
+
+func TestApplyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	sshdConfig := filepath.Join(dir, "sshd_config")
+
+	second := transactionConfig("10.0.0.2 two")
+	second.BeginMarker = "# BEGIN SECOND"
+	second.EndMarker = "# END SECOND"
+	results, err := ApplyFiles([]Target{
+		{Path: hosts, Config: transactionConfig("10.0.0.1 one")},
+		{Path: hosts, Config: second},
+		{Path: sshdConfig, Config: transactionConfig("PasswordAuthentication no")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	for _, result := range results {
+		assert.True(t, result.Changed)
+		assert.Equal(t, ActionInserted, result.Action)
+	}
+
+	actual, err := ioutil.ReadFile(hosts)
+	assert.NoError(t, err)
+	compare(t, `127.0.0.1 localhost
+# BEGIN MANAGED BLOCK
+10.0.0.1 one
+# END MANAGED BLOCK
+# BEGIN SECOND
+10.0.0.2 two
+# END SECOND
+`, string(actual))
+
+	actual, err = ioutil.ReadFile(sshdConfig)
+	assert.NoError(t, err)
+	compare(t, "# BEGIN MANAGED BLOCK\nPasswordAuthentication no\n# END MANAGED BLOCK\n", string(actual))
+}
+
+func TestApplyFilesRollsBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0640); err != nil {
+		log.Fatal(err)
+	}
+	created := filepath.Join(dir, "created")
+	// Its directory is missing, so it cannot be created
+	sshdConfig := filepath.Join(dir, "ssh", "sshd_config")
+
+	first := transactionConfig("10.0.0.1 one")
+	first.Backup = true
+	first.Mode = "0600"
+	_, err = ApplyFiles([]Target{
+		{Path: hosts, Config: first},
+		{Path: created, Config: transactionConfig("new")},
+		{Path: sshdConfig, Config: transactionConfig("PasswordAuthentication no")},
+	})
+
+	var transactionErr *TransactionError
+	assert.True(t, errors.As(err, &transactionErr))
+	assert.Equal(t, 2, transactionErr.Index)
+	assert.True(t, errors.Is(err, ErrUnwritableFile))
+	assert.False(t, errors.Is(err, ErrRollbackFailed))
+
+	actual, err := ioutil.ReadFile(hosts)
+	assert.NoError(t, err)
+	compare(t, "127.0.0.1 localhost\n", string(actual))
+	info, err := os.Stat(hosts)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	assert.NoFileExists(t, created)
+	assert.NoFileExists(t, sshdConfig)
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "backup files should be removed")
+}
+
+func TestApplyFilesFailsBeforeWriting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	invalid := transactionConfig("block")
+	invalid.InsertAfter = "("
+	_, err = ApplyFiles([]Target{
+		{Path: hosts, Config: transactionConfig("10.0.0.1 one")},
+		{Path: hosts, Config: invalid},
+	})
+
+	var transactionErr *TransactionError
+	assert.True(t, errors.As(err, &transactionErr))
+	assert.Equal(t, 1, transactionErr.Index)
+	assert.True(t, errors.Is(err, ErrInvalidPattern))
+	assert.NoFileExists(t, hosts)
+}
+
+func TestApplyFilesMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	remove := transactionConfig("")
+	remove.State = false
+	results, err := ApplyFiles([]Target{{Path: hosts, Config: remove}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Result{{}}, results)
+	assert.NoFileExists(t, hosts)
+
+	mustExist := transactionConfig("10.0.0.1 one")
+	mustExist.MustExist = true
+	_, err = ApplyFiles([]Target{{Path: hosts, Config: mustExist}})
+	assert.ErrorIs(t, err, ErrMissingFile)
+	assert.NoFileExists(t, hosts)
+}
+
+func TestApplyFilesCheckMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transaction")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hosts := filepath.Join(dir, "hosts")
+	config := transactionConfig("10.0.0.1 one")
+	config.Check = true
+	config.Diff = true
+	results, err := ApplyFiles([]Target{{Path: hosts, Config: config}})
+	assert.NoError(t, err)
+	assert.True(t, results[0].Changed)
+	assert.Equal(t, `--- `+os.DevNull+`
++++ `+hosts+`
+@@ -0,0 +1,3 @@
++# BEGIN MANAGED BLOCK
++10.0.0.1 one
++# END MANAGED BLOCK
+`, results[0].Diff)
+	assert.NoFileExists(t, hosts)
+}
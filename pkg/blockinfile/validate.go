@@ -0,0 +1,94 @@
+package blockinfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// validateContent writes content to a temp file and runs the validate command on it, with %s
+// replaced by the path of the temp file. The command is split into arguments like a shell does, see
+// splitCommand, but not run by a shell.
+// The output of a failing command is part of the returned error.
+func validateContent(path, command, content string) error {
+	tmp, err := ioutil.TempFile("", "blockinfile.*."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	args, err := splitCommand(command)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidValidateCommand, err)
+	}
+	for i := range args {
+		args[i] = strings.ReplaceAll(args[i], "%s", tmp.Name())
+	}
+	if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s: %w\n%s", ErrValidationFailed, command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// splitCommand splits command into arguments on whitespace, like a POSIX shell and Ansible's shlex do.
+// Single quotes keep everything up to the next single quote as is. Double quotes keep whitespace,
+// and a backslash escapes the next character outside quotes, or ", \, $ and ` inside double quotes.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", command)
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(command[i])
+			}
+			if i == len(command) {
+				return nil, fmt.Errorf("unterminated double quote in %q", command)
+			}
+		case c == '\\':
+			if i+1 == len(command) {
+				return nil, fmt.Errorf("trailing backslash in %q", command)
+			}
+			i++
+			arg.WriteByte(command[i])
+		default:
+			arg.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,110 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFileValidate(t *testing.T) {
+	f, err := ioutil.TempFile("", "validate_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		State:       true,
+		Block:       "Defaults env_reset",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Validate:    "grep -q env_reset %s",
+	}
+	result, err := ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+	assert.True(t, result.Changed)
+
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, "# BEGIN MANAGED BLOCK\nDefaults env_reset\n# END MANAGED BLOCK\n", string(actual))
+}
+
+func TestApplyFileValidateFails(t *testing.T) {
+	var origText = "line 1\n"
+	f, err := ioutil.TempFile("", "validate_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := f.WriteString(origText); err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		State:       true,
+		Backup:      true,
+		Block:       "invalid",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Validate:    "cat %s /nonexistent",
+	}
+	_, err = ApplyFile(f.Name(), config)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	// The output of the validator is shown
+	assert.Contains(t, err.Error(), "invalid")
+	assert.Contains(t, err.Error(), "/nonexistent")
+
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, origText, string(actual))
+}
+
+func TestApplyValidateWithoutPlaceholder(t *testing.T) {
+	_, _, err := Apply("", Config{State: true, Validate: "visudo -c"})
+	assert.ErrorIs(t, err, ErrInvalidValidateCommand)
+}
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(`sh -c 'test -s %s'`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", "test -s %s"}, args)
+
+	args, err = splitCommand(`  cmd "a \"b\" \$c\d" e\ f ''  `)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cmd", `a "b" $c\d`, "e f", ""}, args)
+
+	for _, command := range []string{`sh -c 'test %s`, `sh -c "test %s`, `test %s \`} {
+		_, err := splitCommand(command)
+		assert.Error(t, err, command)
+		_, _, err = Apply("", Config{State: true, Validate: command})
+		assert.ErrorIs(t, err, ErrInvalidValidateCommand, command)
+	}
+}
+
+func TestApplyFileValidateQuoted(t *testing.T) {
+	f, err := ioutil.TempFile("", "validate_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	config := Config{
+		State:       true,
+		Block:       "Defaults env_reset",
+		BeginMarker: "# BEGIN MANAGED BLOCK",
+		EndMarker:   "# END MANAGED BLOCK",
+		Validate:    `sh -c 'grep -q "Defaults env_reset" %s'`,
+	}
+	_, err = ApplyFile(f.Name(), config)
+	assert.NoError(t, err)
+
+	config.Block = "Defaults  env_reset"
+	_, err = ApplyFile(f.Name(), config)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+}
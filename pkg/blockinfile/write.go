@@ -0,0 +1,111 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFile replaces the content of path atomically: the content is written to a temp file in the
+// same directory, fsynced and renamed over the original, so readers never see a partial file.
+// When the rename is impossible, e.g. for bind-mounted files, or the replacement cannot keep the
+// owner and group of the original, unsafeWrites falls back to rewriting the file in place.
+func writeFile(path, content string, unsafeWrites bool) error {
+	// Replace the file a symlink points to rather than the symlink itself
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(target, content, target)
+	if err != nil && unsafeWrites {
+		return writeFileInPlace(target, content)
+	}
+	return err
+}
+
+// writeFileAtomic replaces path with content, giving it the owner, group, mode and extended
+// attributes of attributesFrom
+func writeFileAtomic(path, content, attributesFrom string) (err error) {
+	info, err := os.Stat(attributesFrom)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.WriteString(content); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = copyFileAttributes(info, attributesFrom, tmp.Name()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself. Not every platform can sync a directory, so this is best effort.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func writeFileInPlace(path, content string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyFileAttributes gives dst the owner, group, mode and extended attributes of src, including
+// ACLs, so replacing src with dst keeps them.
+func copyFileAttributes(info os.FileInfo, src, dst string) error {
+	if err := setFileAttributes(dst, info); err != nil {
+		return err
+	}
+	return copyXattrs(src, dst)
+}
+
+// setFileAttributes gives path the owner, group and mode described by info. It fails when the owner
+// cannot be kept, e.g. when a user other than root writes a file they do not own.
+func setFileAttributes(path string, info os.FileInfo) error {
+	// Change ownership first, since chown clears the setuid and setgid bits
+	if uid, gid, ok := fileOwner(info); ok {
+		current, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if currentUID, currentGID, _ := fileOwner(current); currentUID != uid || currentGID != gid {
+			if err := os.Lchown(path, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Chmod(path, info.Mode()&permissionBits)
+}
@@ -0,0 +1,147 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileReplacesAtomically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(path, []byte("old content\n"), 0640); err != nil {
+		log.Fatal(err)
+	}
+	os.Chmod(path, 0640)
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writeFile(path, "new content\n", false))
+
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	compare(t, "new content\n", string(actual))
+
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(before, after), "file should have been replaced by rename")
+	assert.Equal(t, os.FileMode(0640), after.Mode().Perm())
+
+	// No temp files are left behind
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileFollowsSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target.txt")
+	link := filepath.Join(dir, "link.txt")
+	if err := ioutil.WriteFile(target, []byte("old content\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	assert.NoError(t, writeFile(link, "new content\n", false))
+
+	info, err := os.Lstat(link)
+	assert.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink, "symlink should be kept")
+
+	actual, err := ioutil.ReadFile(target)
+	assert.NoError(t, err)
+	compare(t, "new content\n", string(actual))
+}
+
+func TestWriteFileUnsafeWrites(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping test that requires a directory root cannot write to")
+	}
+
+	dir, err := ioutil.TempDir("", "write_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(path, []byte("old content\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// The temp file cannot be created in a read-only directory, but the file itself is writable
+	os.Chmod(dir, 0555)
+	defer os.Chmod(dir, 0755)
+
+	assert.Error(t, writeFile(path, "new content\n", false))
+	assert.NoError(t, writeFile(path, "new content\n", true))
+
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	compare(t, "new content\n", string(actual))
+}
+
+func TestWriteFileInPlace(t *testing.T) {
+	f, err := ioutil.TempFile("", "in_place_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("a much longer old content\n"); err != nil {
+		log.Fatal(err)
+	}
+	before, err := f.Stat()
+	assert.NoError(t, err)
+
+	assert.NoError(t, writeFileInPlace(f.Name(), "new content\n"))
+
+	after, err := os.Stat(f.Name())
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(before, after), "file should have been rewritten in place")
+
+	actual, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	compare(t, "new content\n", string(actual))
+}
+
+func TestWriteFileKeepsOwner(t *testing.T) {
+	// Giving a file away requires root
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping test that requires root privileges")
+	}
+
+	dir, err := ioutil.TempDir("", "write_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(path, []byte("old content\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	assert.NoError(t, os.Chown(path, 65534, 65534))
+	assert.NoError(t, os.Chmod(path, 0644|os.ModeSetgid))
+
+	assert.NoError(t, writeFile(path, "new content\n", false))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	uid, gid, _ := fileOwner(info)
+	assert.Equal(t, 65534, uid)
+	assert.Equal(t, 65534, gid)
+	assert.Equal(t, 0644|os.ModeSetgid, info.Mode()&permissionBits)
+}
@@ -0,0 +1,30 @@
+package blockinfile
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileKeepsXattrs(t *testing.T) {
+	f, err := ioutil.TempFile("", "xattr_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := syscall.Setxattr(f.Name(), "user.blockinfile", []byte("kept"), 0); err != nil {
+		t.Skip("extended attributes not supported:", err)
+	}
+
+	assert.NoError(t, writeFile(f.Name(), "new content\n", false))
+
+	value, err := getXattr(f.Name(), "user.blockinfile")
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", string(value))
+}
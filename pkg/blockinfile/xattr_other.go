@@ -0,0 +1,8 @@
+//go:build !linux
+
+package blockinfile
+
+// copyXattrs is a no-op on platforms without Linux extended attributes
+func copyXattrs(src, dst string) error {
+	return nil
+}
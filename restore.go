@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+	"github.com/urfave/cli/v2"
+)
+
+// errNoBackup is returned when the requested backup does not exist
+var errNoBackup = errors.New("no backup found")
+
+// newRestoreCommand builds the restore command, which lists the backups of a file or restores one of them
+func newRestoreCommand() *cli.Command {
+	var path, backupDir, timestamp, output string
+	var latest, check, unsafeWrites bool
+	var lockTimeout time.Duration
+
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "list the backups of a file, or restore the file from one of them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "backup-dir",
+				Usage:       "The backup-dir the backups were written to, if any.",
+				Destination: &backupDir,
+			},
+			&cli.BoolFlag{
+				Name:        "check",
+				Usage:       "Show the diff without restoring the file.",
+				Destination: &check,
+			},
+			&cli.BoolFlag{
+				Name:        "latest",
+				Usage:       "Restore the newest backup.",
+				Destination: &latest,
+			},
+			&cli.DurationFlag{
+				Name:        "lock-timeout",
+				Usage:       "How long to wait for another process to release its lock on the file, e.g. '10s'. 0 waits indefinitely.",
+				Destination: &lockTimeout,
+				DefaultText: "0",
+			},
+			&cli.StringFlag{
+				Name:        "output",
+				Usage:       "The format of the result, either 'text' or 'json'.",
+				Destination: &output,
+				DefaultText: outputText,
+				Value:       outputText,
+			},
+			&cli.StringFlag{
+				Name:        "path",
+				Usage:       "The file to restore. If the path is relative, the working directory of where blockinfile is running will be pre-fixed to the path.",
+				Destination: &path,
+			},
+			&cli.StringFlag{
+				Name:        "timestamp",
+				Usage:       "Restore the backup with this timestamp, as listed without --latest or --timestamp, e.g. 20240102T030405.000000000Z.",
+				Destination: &timestamp,
+			},
+			&cli.BoolFlag{
+				Name:        "unsafe-writes",
+				Usage:       "Write the file in place when it cannot be replaced atomically, e.g. for bind-mounted files. Readers may see a partially written file.",
+				Destination: &unsafeWrites,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if output != outputText && output != outputJSON {
+				return cli.Exit(fmt.Sprintf("invalid output %q, must be one of [text|json]", output), exitInvalidFlags)
+			}
+			if latest && timestamp != "" {
+				return cli.Exit("only one of these flags can be used at a time [latest|timestamp]", exitInvalidFlags)
+			}
+			if path == "" {
+				return cli.Exit(blockinfile.ErrMissingPath, exitInvalidFlags)
+			}
+			path = getFullPath(path)
+
+			backups, err := blockinfile.ListBackups(path, backupDir)
+			if err != nil {
+				return cli.Exit(err, exitCode(err))
+			}
+			if !latest && timestamp == "" {
+				return printBackups(c.App.Writer, output, backups)
+			}
+
+			backup, err := selectBackup(backups, latest, timestamp)
+			if err != nil {
+				return cli.Exit(err, exitNoBackup)
+			}
+			result, err := blockinfile.Restore(path, backup.Path, blockinfile.Config{Check: check, Diff: true, UnsafeWrites: unsafeWrites, LockTimeout: lockTimeout})
+			r := entryResult{path: path, check: check, result: result, err: err}
+			if output == outputJSON {
+				if err := writeJSON(c.App.Writer, newJSONResult(r)); err != nil {
+					return err
+				}
+				if err != nil {
+					return cli.Exit("", exitCode(err))
+				}
+				return nil
+			}
+			if err != nil {
+				return cli.Exit(err, exitCode(err))
+			}
+			fmt.Fprint(c.App.Writer, result.Diff)
+			fmt.Fprintln(c.App.Writer, restoreReport(path, backup.Path, check, result))
+			return nil
+		},
+	}
+}
+
+// selectBackup returns the newest backup, or the one with the given timestamp
+func selectBackup(backups []blockinfile.Backup, latest bool, timestamp string) (blockinfile.Backup, error) {
+	for _, backup := range backups {
+		if latest || strings.HasSuffix(backup.Path, "."+timestamp) {
+			return backup, nil
+		}
+	}
+	if latest {
+		return blockinfile.Backup{}, errNoBackup
+	}
+	return blockinfile.Backup{}, fmt.Errorf("%w with timestamp %s", errNoBackup, timestamp)
+}
+
+// printBackups lists backups, newest first
+func printBackups(w io.Writer, output string, backups []blockinfile.Backup) error {
+	if output == outputJSON {
+		if backups == nil {
+			backups = []blockinfile.Backup{}
+		}
+		return writeJSON(w, backups)
+	}
+	for _, backup := range backups {
+		fmt.Fprintln(w, backup.Path)
+	}
+	return nil
+}
+
+// restoreReport describes in one line what happened to path, or would happen in check mode
+func restoreReport(path, backup string, check bool, result blockinfile.Result) string {
+	switch {
+	case !result.Changed:
+		return path + ": unchanged"
+	case check:
+		return fmt.Sprintf("%s: would be restored from %s", path, backup)
+	default:
+		return fmt.Sprintf("%s: restored from %s", path, backup)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/hosts"
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	_, code := runApp("--path", path, "--block", "block 1", "--backup", "true")
+	assert.Equal(t, 0, code)
+	_, code = runApp("--path", path, "--block", "block 2", "--backup", "true")
+	assert.Equal(t, 0, code)
+
+	out, code := runApp("restore", "--path", path)
+	assert.Equal(t, 0, code)
+	backups := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, backups, 2)
+
+	out, code = runApp("restore", "--path", path, "--latest", "--check")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "-block 2\n+block 1\n")
+	assert.Contains(t, out, path+": would be restored from "+backups[0]+"\n")
+
+	timestamp := backups[1][len(path)+1:]
+	out, code = runApp("restore", "--path", path, "--timestamp", timestamp)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, path+": restored from "+backups[1]+"\n")
+
+	actual, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n", string(actual))
+
+	_, code = runApp("restore", "--path", path, "--timestamp", "19700101T000000.000000000Z")
+	assert.Equal(t, exitNoBackup, code)
+
+	_, code = runApp("restore", "--path", path, "--latest", "--timestamp", timestamp)
+	assert.Equal(t, exitInvalidFlags, code)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dustinsand/blockinfile/pkg/blockinfile"
+)
+
+// errInvalidPathPattern is returned for a malformed glob in path, include or exclude
+var errInvalidPathPattern = errors.New("invalid path pattern")
+
+// isPathPattern reports whether path is a glob pattern rather than a single file
+func isPathPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// expandEntries replaces every entry whose path is a glob, or a directory with recursive, by one entry
+// per file it matches. It reports whether any entry was expanded, since the matched files are then
+// reported one by one like a blocks list. A pattern matching no file expands to no entries and is
+// returned in unmatched.
+func expandEntries(entries []options) (expanded []options, anyExpanded bool, unmatched []string, err error) {
+	for _, entry := range entries {
+		paths, ok, err := entry.targetPaths()
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if !ok {
+			expanded = append(expanded, entry)
+			continue
+		}
+		anyExpanded = true
+		if len(paths) == 0 {
+			unmatched = append(unmatched, entry.Path)
+		}
+		for _, path := range paths {
+			entry.Path = path
+			expanded = append(expanded, entry)
+		}
+	}
+	return expanded, anyExpanded, unmatched, nil
+}
+
+// targetPaths returns the files matched by the path of the entry, or false when the path is a
+// single file. Directories matched by a glob are skipped, unless recursive walks them. An existing
+// file whose name contains pattern characters is a single file, and so is a missing one with
+// brackets but no * or ?, e.g. app[1].conf, when create is set, since brackets are common in names.
+func (o options) targetPaths() ([]string, bool, error) {
+	include, err := splitPatterns(o.Include)
+	if err != nil {
+		return nil, false, err
+	}
+	exclude, err := splitPatterns(o.Exclude)
+	if err != nil {
+		return nil, false, err
+	}
+
+	path := getFullPath(o.Path)
+	var roots []string
+	switch {
+	case isPathPattern(o.Path):
+		if info, err := os.Stat(path); err == nil {
+			if !o.Recursive || !info.IsDir() {
+				return nil, false, nil
+			}
+			roots = []string{path}
+			break
+		}
+		if roots, err = filepath.Glob(path); err != nil {
+			return nil, false, fmt.Errorf("%w %q: %w", errInvalidPathPattern, o.Path, err)
+		}
+		if create, _ := strconv.ParseBool(o.Create); len(roots) == 0 && create && !strings.ContainsAny(o.Path, "*?") {
+			return nil, false, nil
+		}
+	case o.Recursive:
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			// A single file, or a missing one to create
+			return nil, false, nil
+		}
+		roots = []string{path}
+	default:
+		return nil, false, nil
+	}
+
+	var paths []string
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, err)
+		}
+		if !info.IsDir() {
+			if matchesPatterns(root, include, exclude) && !blockinfile.IsLockFile(root) {
+				paths = append(paths, root)
+			}
+			continue
+		}
+		if !o.Recursive {
+			continue
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				// Excluding a directory skips everything below it
+				if path != root && matchesAny(filepath.Base(path), exclude) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && matchesPatterns(path, include, exclude) && !blockinfile.IsLockFile(path) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %w", blockinfile.ErrUnreadableFile, err)
+		}
+	}
+	return paths, true, nil
+}
+
+// splitPatterns returns the comma separated glob patterns of patterns, checking their syntax
+func splitPatterns(patterns string) ([]string, error) {
+	if patterns == "" {
+		return nil, nil
+	}
+	split := strings.Split(patterns, ",")
+	for _, pattern := range split {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errInvalidPathPattern, pattern, err)
+		}
+	}
+	return split, nil
+}
+
+// matchesPatterns reports whether the name of the file at path matches one of include, if any, and none of exclude
+func matchesPatterns(path string, include, exclude []string) bool {
+	name := filepath.Base(path)
+	return (len(include) == 0 || matchesAny(name, include)) && !matchesAny(name, exclude)
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		// The patterns were checked by splitPatterns
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTree creates the files at paths below dir, with their parent directories
+func writeTree(dir string, paths ...string) {
+	for _, path := range paths {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func TestExpandEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(dir, "a.conf", "b.conf", "c.txt", "sub/d.conf", "sub/.git/e.conf", "sub/f.conf.bak", "lock/Gemfile",
+		"lock/Gemfile.lock", "lock/.Gemfile.blockinfile.lock")
+
+	paths := func(entries []options) []string {
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		return paths
+	}
+
+	entries, expanded, _, err := expandEntries([]options{{Path: dir + "/*.conf", Block: "block"}})
+	assert.NoError(t, err)
+	assert.True(t, expanded)
+	assert.Equal(t, []string{dir + "/a.conf", dir + "/b.conf"}, paths(entries))
+	assert.Equal(t, "block", entries[1].Block)
+
+	entries, _, _, err = expandEntries([]options{{Path: dir, Recursive: true, Include: "*.conf,*.bak", Exclude: "b.*,.git"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/a.conf", dir + "/sub/d.conf", dir + "/sub/f.conf.bak"}, paths(entries))
+
+	entries, _, _, err = expandEntries([]options{{Path: dir + "/s*", Recursive: true, Include: "*.conf"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/sub/.git/e.conf", dir + "/sub/d.conf"}, paths(entries))
+
+	// Only the lock files of concurrent runs are skipped
+	entries, _, _, err = expandEntries([]options{{Path: dir + "/lock/*"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/lock/Gemfile", dir + "/lock/Gemfile.lock"}, paths(entries))
+	entries, _, _, err = expandEntries([]options{{Path: dir + "/lock", Recursive: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/lock/Gemfile", dir + "/lock/Gemfile.lock"}, paths(entries))
+
+	// Directories matched without recursive and patterns without matches expand to nothing
+	entries, expanded, unmatched, err := expandEntries([]options{{Path: dir + "/s*"}, {Path: dir + "/*.ini"}})
+	assert.NoError(t, err)
+	assert.True(t, expanded)
+	assert.Empty(t, entries)
+	assert.Equal(t, []string{dir + "/s*", dir + "/*.ini"}, unmatched)
+
+	// Single files are kept as they are, even when missing
+	entries, expanded, _, err = expandEntries([]options{{Path: dir + "/missing.conf", Recursive: true}, {Path: dir + "/c.txt", Include: "*.conf"}})
+	assert.NoError(t, err)
+	assert.False(t, expanded)
+	assert.Equal(t, []string{dir + "/missing.conf", dir + "/c.txt"}, paths(entries))
+}
+
+func TestExpandEntriesInvalidPattern(t *testing.T) {
+	_, _, _, err := expandEntries([]options{{Path: "/tmp/[", Block: "block"}})
+	assert.True(t, errors.Is(err, errInvalidPathPattern))
+	assert.Equal(t, exitInvalidFlags, exitCode(err))
+
+	_, _, _, err = expandEntries([]options{{Path: "/tmp", Recursive: true, Exclude: "["}})
+	assert.True(t, errors.Is(err, errInvalidPathPattern))
+}
+
+func TestExpandEntriesLiteralPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets_test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(dir, "app[1].conf", "app1.conf")
+
+	// An existing file is not a pattern, even though app1.conf matches it as one
+	entries, expanded, _, err := expandEntries([]options{{Path: dir + "/app[1].conf"}})
+	assert.NoError(t, err)
+	assert.False(t, expanded)
+	assert.Equal(t, dir+"/app[1].conf", entries[0].Path)
+
+	// A missing file with brackets is created, but not one with * or ?
+	entries, expanded, unmatched, err := expandEntries([]options{
+		{Path: dir + "/app[2].conf", Create: "true"},
+		{Path: dir + "/app[2].conf", Create: "false"},
+		{Path: dir + "/*.ini", Create: "true"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, expanded)
+	assert.Equal(t, []options{{Path: dir + "/app[2].conf", Create: "true"}}, entries)
+	assert.Equal(t, []string{dir + "/app[2].conf", dir + "/*.ini"}, unmatched)
+}